@@ -2,13 +2,25 @@ package blocks
 
 import (
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/su55y/yt_feed/internal/consts"
 	"github.com/su55y/yt_feed/internal/models"
+	"github.com/su55y/yt_feed/internal/placeholder"
+	"github.com/su55y/yt_feed/internal/player"
 )
 
-func PrintChannelMenu(channelId string) []models.Line {
+// videos and playlists lines show cache age, e.g. 'videos · updated 2h ago'
+func PrintChannelMenu(channelId string, uploads, playlists time.Time) []models.Line {
 	actions := []string{
-		"back", "videos", "playlists", "update videos", "update playlists",
+		"back",
+		"videos" + cacheAge(uploads),
+		"playlists" + cacheAge(playlists),
+		"update videos",
+		"update playlists",
 	}
 	lines := make([]models.Line, 0)
 	for _, a := range actions {
@@ -21,26 +33,199 @@ func PrintChannelMenu(channelId string) []models.Line {
 	return lines
 }
 
-func PrintChannels(channels map[string]models.Channel, updating bool) []models.Line {
+// MenuAction returns menu line text without details after ' · '
+func MenuAction(text string) string {
+	if i := strings.Index(text, " · "); i >= 0 {
+		return text[:i]
+	}
+	return text
+}
+
+func cacheAge(fetched time.Time) string {
+	if fetched.IsZero() {
+		return ""
+	}
+	age := time.Since(fetched)
+	switch {
+	case age < time.Minute:
+		return " · updated just now"
+	case age < time.Hour:
+		return fmt.Sprintf(" · updated %dm ago", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf(" · updated %dh ago", int(age.Hours()))
+	}
+	return fmt.Sprintf(" · updated %dd ago", int(age.Hours()/24))
+}
+
+// entries shown above channels list
+type Extras struct {
+	Queued    int
+	Downloads int
+}
+
+// Progress of running channels update, channels missing from Done are
+// still updating
+type Progress struct {
+	Done map[string]error
+}
+
+// channels are sorted by title to keep lines in place while progress frames
+// are printed, nil progress means that no update is running
+func PrintChannels(
+	channels map[string]models.Channel,
+	extras Extras,
+	progress *Progress,
+) []models.Line {
 	lines := make([]models.Line, 0)
-	for _, c := range channels {
+	if extras.Queued > 0 {
+		lines = append(lines, models.Line{
+			Text: fmt.Sprintf("queue (%d)", extras.Queued),
+			Data: consts.D_QUEUE,
+		})
+	}
+	if extras.Downloads > 0 {
 		lines = append(lines, models.Line{
-			Text:          c.Title,
-			Data:          c.Id,
-			Icon:          c.ThumbnailPath,
-			Nonselectable: updating,
+			Text: fmt.Sprintf("downloads (%d)", extras.Downloads),
+			Data: consts.D_DOWNLOADS,
 		})
 	}
+
+	sorted := make([]models.Channel, 0, len(channels))
+	for _, c := range channels {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.ToLower(sorted[i].Title) < strings.ToLower(sorted[j].Title)
+	})
+
+	for _, c := range sorted {
+		line := models.Line{
+			Text: c.Title,
+			Data: c.Id,
+			Icon: channelIcon(c),
+		}
+		if progress != nil {
+			err, done := progress.Done[c.Id]
+			switch {
+			case !done:
+				line.Nonselectable = true
+			case err != nil:
+				line.Text += " · update failed"
+			}
+		}
+		lines = append(lines, line)
+	}
 	return lines
 }
 
-func PrintVideos(playlist models.Playlist, channelsId string) models.Blocks {
+// progress message, e.g. 'updating... 12/40 channels'
+func PrintProgress(progress *Progress, total int) string {
+	return fmt.Sprintf("updating... %d/%d channels", len(progress.Done), total)
+}
+
+func PrintVideos(
+	playlist models.Playlist,
+	channelsId string,
+	history map[string]models.HistoryEntry,
+) models.Blocks {
 	return models.Blocks{
-		Lines:   getVideosLines(playlist.Videos, channelsId),
+		Lines:   getVideosLines(playlist.Videos, channelsId, history),
 		Message: fmt.Sprintf("last %d videos of %s playlist", len(playlist.Videos), playlist.Title),
 	}
 }
 
+// video menu is shown instead of playing when video has resume point
+func PrintVideoMenu(video models.Video, entry models.HistoryEntry, channelId string) models.Blocks {
+	return models.Blocks{
+		Lines: []models.Line{
+			{Text: "back", Data: "videos:" + channelId},
+			{
+				Text: "resume at " + player.FormatPosition(entry.Position),
+				Data: consts.D_RESUME + video.Id,
+				Icon: VideoIcon(video.ThumbnailPath),
+			},
+			{
+				Text: "play from start",
+				Data: consts.D_START + video.Id,
+				Icon: VideoIcon(video.ThumbnailPath),
+			},
+		},
+		Message: video.Title,
+	}
+}
+
+func PrintDownloadMenu(video models.Video, presets []string, channelId string) models.Blocks {
+	lines := []models.Line{{Text: "back", Data: "videos:" + channelId}}
+	for _, p := range presets {
+		lines = append(lines, models.Line{
+			Text: "download " + p,
+			Data: consts.D_DOWNLOAD + p + ":" + video.Id,
+			Icon: VideoIcon(video.ThumbnailPath),
+		})
+	}
+
+	return models.Blocks{
+		Lines:   lines,
+		Message: "download " + video.Title,
+	}
+}
+
+// active downloads are followed by finished ones, newest first
+func PrintDownloads(active, finished []models.Download) models.Blocks {
+	lines := []models.Line{
+		{Text: "back"},
+		{Text: "refresh", Data: consts.D_DOWNLOADS},
+	}
+	for _, d := range active {
+		lines = append(lines, models.Line{
+			Text:          fmt.Sprintf("[%s %.1f%%] %s (%s)", d.Status, d.Progress, d.Title, d.Preset),
+			Data:          d.VideoId,
+			Nonselectable: true,
+		})
+	}
+	for i := len(finished) - 1; i >= 0; i-- {
+		d := finished[i]
+		switch d.Status {
+		case consts.DL_DONE:
+			lines = append(lines, models.Line{
+				Text: fmt.Sprintf("%s (%s, %.1fM)", d.Title, d.Preset, float64(d.Size)/(1<<20)),
+				Data: consts.D_FILE + d.Path,
+			})
+		case consts.DL_FAILED:
+			lines = append(lines, models.Line{
+				Text:          fmt.Sprintf("[failed] %s: %s", d.Title, d.Error),
+				Data:          d.VideoId,
+				Nonselectable: true,
+			})
+		}
+	}
+
+	return models.Blocks{
+		Lines:   lines,
+		Message: fmt.Sprintf("%d active, %d finished downloads", len(active), len(finished)),
+	}
+}
+
+func PrintQueue(videos []models.Video) models.Blocks {
+	lines := []models.Line{
+		{Text: "back"},
+		{Text: "play queue", Data: consts.D_QUEUE},
+		{Text: "clear queue", Data: consts.D_QUEUE},
+	}
+	for _, v := range videos {
+		lines = append(lines, models.Line{
+			Text: v.Title,
+			Data: v.Id,
+			Icon: VideoIcon(v.ThumbnailPath),
+		})
+	}
+
+	return models.Blocks{
+		Lines:   lines,
+		Message: fmt.Sprintf("%d videos in queue", len(videos)),
+	}
+}
+
 func PrintPlaylists(playlists map[string]models.Playlist, channelId string) models.Blocks {
 	return models.Blocks{
 		Lines:   getPlaylistsLines(playlists, channelId),
@@ -48,14 +233,21 @@ func PrintPlaylists(playlists map[string]models.Playlist, channelId string) mode
 	}
 }
 
-func getVideosLines(videos []models.Video, channelId string) []models.Line {
-	lines := []models.Line{{Text: "back", Data: "channel:" + channelId}}
+func getVideosLines(
+	videos []models.Video,
+	channelId string,
+	history map[string]models.HistoryEntry,
+) []models.Line {
+	lines := []models.Line{
+		{Text: "back", Data: "channel:" + channelId},
+		{Text: "play all", Data: channelId},
+	}
 	for _, v := range videos {
-		if v.Title != "Private video" {
+		if !v.Private() {
 			lines = append(lines, models.Line{
-				Text: v.Title,
+				Text: historyMark(history[v.Id]) + v.Title,
 				Data: v.Id,
-				Icon: v.ThumbnailPath,
+				Icon: VideoIcon(v.ThumbnailPath),
 			})
 		}
 	}
@@ -69,9 +261,45 @@ func getPlaylistsLines(playlists map[string]models.Playlist, channelId string) [
 		lines = append(lines, models.Line{
 			Text: v.Title,
 			Data: v.Id,
-			Icon: v.ThumbnailPath,
+			Icon: VideoIcon(v.ThumbnailPath),
 		})
 	}
 
 	return lines
 }
+
+// channel thumbnail, or initials placeholder when it's missing
+func channelIcon(c models.Channel) string {
+	if exists(c.ThumbnailPath) {
+		return c.ThumbnailPath
+	}
+	return placeholder.Channel(c.Id, c.Title)
+}
+
+// VideoIcon returns video or playlist thumbnail, or play glyph placeholder
+// when it's missing
+func VideoIcon(path string) string {
+	if exists(path) {
+		return path
+	}
+	return placeholder.Video()
+}
+
+func exists(path string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// prefix for watched and partially watched videos
+func historyMark(entry models.HistoryEntry) string {
+	switch {
+	case entry.Watched:
+		return "✓ "
+	case entry.Resumable():
+		return fmt.Sprintf("[%s] ", player.FormatPosition(entry.Position))
+	}
+	return ""
+}
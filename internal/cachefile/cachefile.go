@@ -0,0 +1,190 @@
+// Package cachefile reads and writes JSON cache files. Writes go to a temp
+// file which is synced and renamed over the target, so readers never see
+// partially written files. Writers of the same directory are serialized by
+// an advisory lock, which also works between different yt_feed instances.
+// Data is wrapped into versioned envelope, files of older versions are
+// upgraded on first read by registered migrations and written back
+package cachefile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/su55y/yt_feed/pkg/fsutil"
+)
+
+const lockFile = ".lock"
+
+var (
+	// returned when file can't be decoded, such file is moved aside so
+	// it's fetched again on the next read
+	ErrCorrupt = errors.New("corrupt cache file")
+	// returned when file was invalidated by migration or was written by
+	// newer version
+	ErrOutdated = errors.New("outdated cache file")
+)
+
+// Unusable reports whether read failed because of file content, such
+// files should be fetched again
+func Unusable(err error) bool {
+	return errors.Is(err, ErrCorrupt) || errors.Is(err, ErrOutdated)
+}
+
+type envelope struct {
+	Version *int            `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Read decodes JSON file into v, v is left untouched when file is missing
+// or unusable. Outdated file is migrated once under directory lock and
+// written back at current version
+func Read(path string, v interface{}) error {
+	version, data, err := load(path)
+	if err != nil {
+		return err
+	}
+	if version == Version {
+		return decode(path, data, v)
+	}
+
+	unlock, err := lock(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return readLocked(path, v)
+}
+
+// reads file while holding directory lock, outdated file is migrated and
+// written back, so migration runs once per file
+func readLocked(path string, v interface{}) error {
+	version, data, err := load(path)
+	if err != nil {
+		return err
+	}
+	if version == Version {
+		return decode(path, data, v)
+	}
+
+	if data, err = migrate(filepath.Base(path), version, data); err != nil {
+		log.Printf("%#v migration error: %s\n", path, err.Error())
+		if err := os.Remove(path); err != nil {
+			log.Printf("remove outdated %#v file error: %s\n", path, err.Error())
+			return err
+		}
+		return fmt.Errorf("%w %s: %s", ErrOutdated, filepath.Base(path), err.Error())
+	}
+	if err := decode(path, data, v); err != nil {
+		return err
+	}
+	if err := write(path, data); err != nil {
+		// migrated data is still usable, migration is retried on next read
+		log.Printf("write migrated %#v file error: %s\n", path, err.Error())
+	}
+	return nil
+}
+
+// returns version and data of file, files written before versioning
+// contain bare data and have version 0
+func load(path string) (int, json.RawMessage, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if !json.Valid(raw) {
+		return 0, nil, moveAside(path, errors.New("invalid json"))
+	}
+
+	version, data := 0, json.RawMessage(raw)
+	var e envelope
+	if err := json.Unmarshal(raw, &e); err == nil && e.Version != nil {
+		version, data = *e.Version, e.Data
+	}
+
+	if version > Version {
+		return 0, nil, fmt.Errorf("%w %s: version %d is newer than %d",
+			ErrOutdated, filepath.Base(path), version, Version)
+	}
+	return version, data, nil
+}
+
+func decode(path string, data json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return moveAside(path, err)
+	}
+	return nil
+}
+
+func moveAside(path string, err error) error {
+	log.Printf("%#v unmarshal error: %s\n", path, err.Error())
+	if err := os.Rename(path, path+".corrupt"); err != nil {
+		log.Printf("move corrupt %#v file error: %s\n", path, err.Error())
+		return err
+	}
+	return fmt.Errorf("%w %s: %s", ErrCorrupt, filepath.Base(path), err.Error())
+}
+
+// Write atomically replaces file with JSON encoded v
+func Write(path string, v interface{}) error {
+	unlock, err := lock(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return write(path, v)
+}
+
+// Update reads file into v, calls fn and writes v back while holding
+// directory lock, so updates of other instances are not lost. Missing or
+// unusable file leaves v as is, error of fn cancels write
+func Update(path string, v interface{}, fn func() error) error {
+	unlock, err := lock(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := readLocked(path, v); err != nil && !errors.Is(err, os.ErrNotExist) && !Unusable(err) {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return write(path, v)
+}
+
+func write(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	version := Version
+	err = fsutil.WriteFileAtomic(path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(&envelope{Version: &version, Data: data})
+	})
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// rename is durable only after directory itself is synced
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return err
+	}
+	return nil
+}
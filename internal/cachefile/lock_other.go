@@ -0,0 +1,13 @@
+//go:build !unix
+
+package cachefile
+
+import "sync"
+
+// no flock here, instances are serialized only within the process
+var mu sync.Mutex
+
+func lock(dir string) (func(), error) {
+	mu.Lock()
+	return mu.Unlock, nil
+}
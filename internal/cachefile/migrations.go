@@ -0,0 +1,53 @@
+package cachefile
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Version of cache files schema. Bump it together with registering
+// migration to the new version when cached models change
+const Version = 2
+
+// Migration upgrades data of cache file with given name from previous
+// version, returned error invalidates file, so it's fetched again
+type Migration func(name string, data json.RawMessage) (json.RawMessage, error)
+
+var (
+	migrationsMu sync.Mutex
+	migrations   = map[int]Migration{
+		// files written before versioning contain bare data of the same
+		// models, only envelope is added
+		1: func(name string, data json.RawMessage) (json.RawMessage, error) {
+			return data, nil
+		},
+	}
+)
+
+// Register adds migration to version, should be called from init
+func Register(version int, m Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	if _, ok := migrations[version]; ok {
+		panic(fmt.Sprintf("cachefile: migration to version %d is already registered", version))
+	}
+	migrations[version] = m
+}
+
+// applies migrations one by one up to current version
+func migrate(name string, version int, data json.RawMessage) (json.RawMessage, error) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	for v := version + 1; v <= Version; v++ {
+		m, ok := migrations[v]
+		if !ok {
+			return nil, fmt.Errorf("no migration to version %d", v)
+		}
+		var err error
+		if data, err = m(name, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"regexp"
 	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,13 +14,117 @@ type AppConfig struct {
 	API_KEY    string `yaml:"api_key"`
 	ApiKeyPath string `yaml:"api_key_path"`
 	// alternative cache path, overrides default if directory exists
-	CachePath  string   `yaml:"cache_dir"`
-	MaxResults int64    `yaml:"max_results"`
-	Region     string   `yaml:"region"`
-	ThumbOff   bool     `yaml:"thumbnails_disable"`
-	ThumbSize  string   `yaml:"thumbnails_size"`
-	Channels   []string `yaml:"channels"`
-	ThumbDir   string
+	CachePath  string `yaml:"cache_dir"`
+	MaxResults int64  `yaml:"max_results"`
+	Region     string `yaml:"region"`
+	ThumbOff   bool   `yaml:"thumbnails_disable"`
+	ThumbSize  string `yaml:"thumbnails_size"`
+	// per-type sizes, thumbnails_size is used for empty ones
+	ThumbSizes ThumbSizesConfig `yaml:"thumbnails_sizes"`
+	KeepOpen   bool             `yaml:"keep_open"`
+	// max channels updated at once, also limits thumbnails downloads
+	Concurrency int `yaml:"concurrency"`
+	// channel update is canceled after timeout, "60s" by default
+	ChannelTimeout time.Duration `yaml:"channel_timeout"`
+	// cache size limit, oldest thumbnails are removed after update when
+	// cache exceeds it, 0 means no limit
+	CacheMaxMB int64 `yaml:"cache_max_mb"`
+	// percent of duration after which video is marked as watched
+	WatchedPercent int                 `yaml:"watched_percent"`
+	Channels       []string            `yaml:"channels"`
+	Downloads      DownloadsConfig     `yaml:"downloads"`
+	AutoDownload   []AutoDownloadRule  `yaml:"auto_download"`
+	Notifications  NotificationsConfig `yaml:"notifications"`
+	Hooks          HooksConfig         `yaml:"hooks"`
+	Quota          QuotaConfig         `yaml:"quota"`
+	TTL            TTLConfig           `yaml:"ttl"`
+	Icons          IconsConfig         `yaml:"icons"`
+	ThumbDir       string
+}
+
+type DownloadsConfig struct {
+	// directory for downloaded videos, "<cache_dir>/downloads" by default
+	Dir string `yaml:"dir"`
+	// yt-dlp command template with {format}, {output}, {url} and {id} placeholders
+	Command []string `yaml:"command"`
+	// format presets by name, passed as {format}
+	Presets map[string]string `yaml:"presets"`
+	// max simultaneous downloads
+	Parallel int `yaml:"parallel"`
+}
+
+type NotificationsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// "notify-send" or "dbus"
+	Backend string `yaml:"backend"`
+	// notify-send command template with {summary}, {body} and {icon} placeholders
+	Command []string `yaml:"command"`
+	// channels ids without notifications
+	Mute []string `yaml:"mute"`
+}
+
+type QuotaConfig struct {
+	// daily budget in API units, 10000 by default
+	Budget int64 `yaml:"budget"`
+	// part of the budget after which non-essential calls are deferred
+	Reserve float64 `yaml:"reserve"`
+}
+
+// thumbnails post-processing, processed icons are cached as PNG next to
+// downloaded thumbnails
+type IconsConfig struct {
+	// max icon width and height in pixels, 0 keeps thumbnails size
+	Size int `yaml:"size"`
+	// crop black bars of letterboxed video thumbnails
+	Crop bool `yaml:"crop"`
+	// circular channel avatars
+	CircleAvatars bool `yaml:"circle_avatars"`
+}
+
+// Enabled reports whether thumbnails should be processed
+func (c IconsConfig) Enabled() bool {
+	return c.Size > 0 || c.Crop || c.CircleAvatars
+}
+
+// thumbnails sizes of channels, playlists and videos: high, medium or default
+type ThumbSizesConfig struct {
+	Channels  string `yaml:"channels"`
+	Playlists string `yaml:"playlists"`
+	Videos    string `yaml:"videos"`
+}
+
+// cache is refreshed on read after it gets older than ttl
+type TTLConfig struct {
+	// 168h by default
+	Channels time.Duration `yaml:"channels"`
+	// 1h by default
+	Uploads time.Duration `yaml:"uploads"`
+	// 24h by default
+	Playlists time.Duration `yaml:"playlists"`
+}
+
+type HooksConfig struct {
+	OnNewVideo       []Hook `yaml:"on_new_video"`
+	OnUpdateFailed   []Hook `yaml:"on_update_failed"`
+	OnChannelRenamed []Hook `yaml:"on_channel_renamed"`
+}
+
+// hook posts JSON payload to url, or runs command if url is empty
+type Hook struct {
+	URL     string   `yaml:"url"`
+	Command []string `yaml:"command"`
+}
+
+// downloads new uploads of the channel
+type AutoDownloadRule struct {
+	Channel string `yaml:"channel"`
+	Preset  string `yaml:"preset"`
+	// regexp for video titles, all videos are downloaded if empty
+	Match string `yaml:"match"`
+	// overrides downloads.command
+	Command  []string `yaml:"command"`
+	KeepLast int      `yaml:"keep_last"`
+	MaxGB    float64  `yaml:"max_gb"`
 }
 
 var (
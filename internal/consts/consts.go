@@ -5,12 +5,61 @@ const (
 	APP_NAME        = "yt_feed"
 	APP_CONFIG_NAME = "config.yaml"
 
+	// commands
+	CMD_TUI   = "tui"
+	CMD_PLAY  = "play"
+	CMD_CACHE = "cache"
+
+	// cache command actions
+	CACHE_STATS  = "stats"
+	CACHE_GC     = "gc"
+	CACHE_VERIFY = "verify"
+	CACHE_CLEAR  = "clear"
+
 	// blocks input action names
 	IN_EXECUTE_CUSTOM_ITEM = "execute custom input"
 	IN_CUSTOM_KEY          = "custom key"
 	IN_SELECT_ENTRY        = "select entry"
 	IN_ACTIVE_ENTRY        = "active entry"
 
+	// custom keys (kb-custom-N)
+	KEY_ENQUEUE  = "1"
+	KEY_DOWNLOAD = "2"
+
+	// blocks lines data
+	D_QUEUE     = "queue"
+	D_RESUME    = "resume:"
+	D_START     = "start:"
+	D_DOWNLOAD  = "download:"
+	D_DOWNLOADS = "downloads"
+	D_FILE      = "file:"
+
+	// storage events
+	EV_NEW_VIDEOS      = "new_videos"
+	EV_UPDATE_FAILED   = "update_failed"
+	EV_CHANNEL_RENAMED = "channel_renamed"
+	EV_CHANNEL_UPDATED = "channel_updated"
+
+	// hooks names
+	HOOK_NEW_VIDEO       = "on_new_video"
+	HOOK_UPDATE_FAILED   = "on_update_failed"
+	HOOK_CHANNEL_RENAMED = "on_channel_renamed"
+
+	// notifications backends
+	NOTIFY_COMMAND = "notify-send"
+	NOTIFY_DBUS    = "dbus"
+
+	// download statuses
+	DL_QUEUED      = "queued"
+	DL_DOWNLOADING = "downloading"
+	DL_DONE        = "done"
+	DL_FAILED      = "failed"
+
+	// download format presets
+	PRESET_BEST  = "best"
+	PRESET_720P  = "720p"
+	PRESET_AUDIO = "audio"
+
 	// app env names
 	ENV_YT_API_KEY   = "YT_FEED_API_KEY"
 	ENV_YT_CACHE_DIR = "YT_FEED_CACHE_DIR"
@@ -40,6 +89,92 @@ thumbnails_disable: false
 # thumbnails size: high(~15-30k),medium(~8-15k),default(~3-4k)
 thumbnails_size: "default"
 
+# thumbnails size can be set per type, next available size is used when
+# thumbnail of chosen size is missing
+# thumbnails_sizes:
+#   channels: "medium"
+#   playlists: "default"
+#   videos: "default"
+
+# thumbnails can be processed into PNG icons: resized to fit into size x size
+# pixels, cropped from black bars and made circular for channels
+# icons:
+#   size: 96
+#   crop: true
+#   circle_avatars: true
+
+# keep menu open after starting mpv
+# videos can be added to the play queue with kb-custom-1 (Alt+1 by default)
+keep_open: false
+
+# video is marked as watched after playing this percent of its duration
+watched_percent: 90
+
+# max channels updated at once, thumbnails downloads are limited by the same value
+concurrency: 8
+
+# channel update is canceled when it takes longer than timeout
+channel_timeout: 60s
+
+# oldest thumbnails are removed after update when cache gets bigger than
+# this size in megabytes, 0 means no limit. Orphaned files can be removed
+# with 'yt_feed cache gc'
+cache_max_mb: 0
+
+# videos are downloaded with yt-dlp, press kb-custom-2 (Alt+2) on a video
+# downloads:
+#   dir: "/path/to/videos"
+#   command: ["yt-dlp", "--newline", "--progress", "--print", "after_move:filepath",
+#     "-f", "{format}", "-o", "{output}", "{url}"]
+#   presets:
+#     best: "bv*+ba/b"
+#     720p: "bv*[height<=720]+ba/b[height<=720]"
+#     audio: "ba/b"
+#   parallel: 2
+
+# rules for downloading new uploads, fired when channel is updated
+# auto_download:
+#   - channel: "<channel id>"
+#     preset: "audio"
+#     # download only titles matching this regexp
+#     match: "(?i)podcast"
+#     # optional yt-dlp command template, downloads.command by default
+#     # command: ["yt-dlp", "-x", "-o", "{output}", "{url}"]
+#     # retention limits, oldest files are removed
+#     keep_last: 10
+#     max_gb: 2.5
+
+# desktop notifications about new videos found on startup update
+# notifications:
+#   enabled: true
+#   # "notify-send" or "dbus"
+#   backend: "notify-send"
+#   command: ["notify-send", "-a", "yt_feed", "-i", "{icon}", "{summary}", "{body}"]
+#   mute:
+#     - "<channel id>"
+
+# hooks for feed events, each hook either posts JSON payload to url or
+# runs command with YT_FEED_* env variables
+# hooks:
+#   on_new_video:
+#     - url: "http://localhost:8080/yt_feed"
+#   on_update_failed:
+#     - command: ["sh", "-c", "echo $YT_FEED_CHANNEL_ID $YT_FEED_ERROR >> /tmp/yt_feed_errors"]
+#   on_channel_renamed:
+#     - command: ["notify-send", "channel renamed"]
+
+# estimated daily API quota usage, playlists refresh is deferred after
+# reserve part of the budget is spent
+# quota:
+#   budget: 10000
+#   reserve: 0.9
+
+# cache is refreshed only after it gets older than ttl
+# ttl:
+#   channels: 168h
+#   uploads: 1h
+#   playlists: 24h
+
 # channels is an array of channels ids
 # channels:
 #   - "value1"
@@ -61,6 +196,8 @@ thumbnails_size: "default"
 	ERR_NO_API_KEY_FILE   = "api key not found in '%s': %v"
 	ERR_API_KEY_FILE_READ = "no api key in '%s'"
 	ERR_CONFIG_LOAD       = "load config error: %v"
+	ERR_UNKNOWN_CMD       = "unknown command '%s'"
+	ERR_CACHE_USAGE       = "usage: yt_feed cache stats|gc|verify|clear [--channel id]"
 
 	// size prefixes
 	SP_HIGH    = "high"
@@ -76,5 +213,11 @@ thumbnails_size: "default"
 	EXT_JPG  = ".jpg"
 
 	// dirs
-	THUMB_DIR_NAME = "thumbnails"
+	THUMB_DIR_NAME       = "thumbnails"
+	PLACEHOLDER_DIR_NAME = "placeholders"
+	STORE_DIR_NAME       = "store"
+
+	// files
+	PLAYLIST_FILE_NAME = "queue.m3u"
+	DOWNLOADS_DIR_NAME = "downloads"
 )
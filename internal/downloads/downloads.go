@@ -0,0 +1,303 @@
+package downloads
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/su55y/yt_feed/internal/config"
+	"github.com/su55y/yt_feed/internal/consts"
+	"github.com/su55y/yt_feed/internal/models"
+	"github.com/su55y/yt_feed/internal/player"
+	"github.com/su55y/yt_feed/internal/storage"
+)
+
+const (
+	queueSize = 100
+
+	defaultParallel = 2
+)
+
+var (
+	defaultCommand = []string{
+		"yt-dlp", "--newline", "--progress",
+		"--print", "after_move:filepath",
+		"-f", "{format}", "-o", "{output}", "{url}",
+	}
+	defaultPresets = map[string]string{
+		consts.PRESET_BEST:  "bv*+ba/b",
+		consts.PRESET_720P:  "bv*[height<=720]+ba/b[height<=720]",
+		consts.PRESET_AUDIO: "ba/b",
+	}
+
+	progressPattern = regexp.MustCompile(`^\[download\]\s+([0-9.]+)%`)
+)
+
+type job struct {
+	download models.Download
+	// rule which enqueued download, nil for manual downloads
+	rule *rule
+}
+
+type Manager struct {
+	AppConfig *config.AppConfig
+	Storage   *storage.Storage
+
+	dir     string
+	command []string
+	presets map[string]string
+	rules   []*rule
+	mu      *sync.Mutex
+	jobs    []*job
+	queue   chan *job
+}
+
+// New starts download workers, their count is limited by downloads.parallel
+func New(conf *config.AppConfig, stor *storage.Storage) *Manager {
+	m := &Manager{
+		AppConfig: conf,
+		Storage:   stor,
+		dir:       conf.Downloads.Dir,
+		command:   conf.Downloads.Command,
+		presets:   make(map[string]string, 0),
+		rules:     compileRules(conf.AutoDownload),
+		mu:        &sync.Mutex{},
+		queue:     make(chan *job, queueSize),
+	}
+
+	if len(m.dir) == 0 {
+		m.dir = filepath.Join(conf.CachePath, consts.DOWNLOADS_DIR_NAME)
+	}
+	if len(m.command) == 0 {
+		m.command = defaultCommand
+	}
+	for k, v := range defaultPresets {
+		m.presets[k] = v
+	}
+	for k, v := range conf.Downloads.Presets {
+		m.presets[k] = v
+	}
+
+	parallel := conf.Downloads.Parallel
+	if parallel <= 0 {
+		parallel = defaultParallel
+	}
+	for i := 0; i < parallel; i++ {
+		go m.worker()
+	}
+	return m
+}
+
+// returns sorted preset names
+func (m *Manager) Presets() []string {
+	names := make([]string, 0, len(m.presets))
+	for k := range m.presets {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enqueue adds video to the download queue
+func (m *Manager) Enqueue(v models.Video, channelId, preset string) error {
+	return m.enqueue(v, channelId, preset, nil)
+}
+
+func (m *Manager) enqueue(v models.Video, channelId, preset string, r *rule) error {
+	if _, ok := m.presets[preset]; !ok {
+		return fmt.Errorf("unknown download preset '%s'", preset)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, j := range m.jobs {
+		if j.download.VideoId == v.Id && j.download.Preset == preset {
+			return errors.New("video is already in download queue")
+		}
+	}
+
+	j := &job{download: models.Download{
+		VideoId:   v.Id,
+		Title:     v.Title,
+		ChannelId: channelId,
+		Preset:    preset,
+		Status:    consts.DL_QUEUED,
+		Auto:      r != nil,
+	}, rule: r}
+	select {
+	case m.queue <- j:
+	default:
+		return errors.New("download queue is full")
+	}
+	m.jobs = append(m.jobs, j)
+	return nil
+}
+
+// returns snapshot of queued and running downloads
+func (m *Manager) Active() []models.Download {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	active := make([]models.Download, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		active = append(active, j.download)
+	}
+	return active
+}
+
+func (m *Manager) worker() {
+	for j := range m.queue {
+		m.run(j)
+	}
+}
+
+func (m *Manager) run(j *job) {
+	m.update(j, func(d *models.Download) { d.Status = consts.DL_DOWNLOADING })
+
+	path, err := m.download(j)
+	m.mu.Lock()
+	for i, active := range m.jobs {
+		if active == j {
+			m.jobs = append(m.jobs[:i], m.jobs[i+1:]...)
+			break
+		}
+	}
+	d := j.download
+	m.mu.Unlock()
+
+	d.Finished = time.Now()
+	if err != nil {
+		log.Printf("download %s error: %s\n", d.VideoId, err.Error())
+		d.Status = consts.DL_FAILED
+		d.Error = err.Error()
+	} else {
+		d.Status = consts.DL_DONE
+		d.Progress = 100
+		d.Path = path
+		if info, err := os.Stat(path); err == nil {
+			d.Size = info.Size()
+		}
+	}
+
+	if err := m.Storage.SaveDownload(d); err != nil {
+		log.Printf("save download %s error: %s\n", d.VideoId, err.Error())
+	}
+	if j.rule != nil {
+		m.prune(j.rule)
+	}
+}
+
+func (m *Manager) update(j *job, f func(d *models.Download)) {
+	m.mu.Lock()
+	f(&j.download)
+	m.mu.Unlock()
+}
+
+// runs yt-dlp and returns path of downloaded file
+func (m *Manager) download(j *job) (string, error) {
+	if err := os.MkdirAll(m.dir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	command := m.command
+	if j.rule != nil && len(j.rule.Command) > 0 {
+		command = j.rule.Command
+	}
+	args := expand(command, map[string]string{
+		"{format}": m.presets[j.download.Preset],
+		"{output}": filepath.Join(m.dir, "%(title)s [%(id)s].%(ext)s"),
+		"{url}":    player.VideoURL(j.download.VideoId),
+		"{id}":     j.download.VideoId,
+	})
+
+	pr, pw := io.Pipe()
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdout = pw
+	c.Stderr = pw
+	if err := c.Start(); err != nil {
+		return "", err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+		pw.Close()
+	}()
+
+	var path, lastLine string
+	scanner := bufio.NewScanner(pr)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if p, ok := parseProgress(line); ok {
+			m.update(j, func(d *models.Download) { d.Progress = p })
+			continue
+		}
+		if filepath.IsAbs(line) && exists(line) {
+			path = line
+		}
+		lastLine = line
+	}
+
+	if err := <-done; err != nil {
+		if len(lastLine) > 0 {
+			return "", fmt.Errorf("%s: %s", err.Error(), lastLine)
+		}
+		return "", err
+	}
+
+	if len(path) == 0 {
+		path = m.find(j.download.VideoId)
+	}
+	if len(path) == 0 {
+		return "", errors.New("downloaded file not found")
+	}
+	return path, nil
+}
+
+// find downloaded file by video id in default output name
+func (m *Manager) find(videoId string) string {
+	matches, err := filepath.Glob(filepath.Join(m.dir, "*\\["+videoId+"\\].*"))
+	if err != nil || len(matches) == 0 {
+		return ""
+	}
+	return matches[0]
+}
+
+// parse yt-dlp '[download]  42.1% of ...' line
+func parseProgress(line string) (float64, bool) {
+	match := progressPattern.FindStringSubmatch(line)
+	if match == nil {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(match[1], 64)
+	return p, err == nil
+}
+
+func expand(template []string, values map[string]string) []string {
+	args := make([]string, 0, len(template))
+	for _, a := range template {
+		for k, v := range values {
+			a = strings.ReplaceAll(a, k, v)
+		}
+		args = append(args, a)
+	}
+	return args
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, os.ErrNotExist) && err == nil
+}
@@ -0,0 +1,105 @@
+package downloads
+
+import (
+	"html"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/su55y/yt_feed/internal/config"
+	"github.com/su55y/yt_feed/internal/consts"
+	"github.com/su55y/yt_feed/internal/models"
+	"github.com/su55y/yt_feed/internal/storage"
+)
+
+type rule struct {
+	config.AutoDownloadRule
+	pattern *regexp.Regexp
+}
+
+func compileRules(conf []config.AutoDownloadRule) []*rule {
+	rules := make([]*rule, 0, len(conf))
+	for _, c := range conf {
+		r := &rule{AutoDownloadRule: c}
+		if len(r.Preset) == 0 {
+			r.Preset = consts.PRESET_BEST
+		}
+		if len(c.Match) > 0 {
+			pattern, err := regexp.Compile(c.Match)
+			if err != nil {
+				log.Printf("auto_download rule for %s skipped: %s\n", c.Channel, err.Error())
+				continue
+			}
+			r.pattern = pattern
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func (r *rule) matches(channelId string, v models.Video) bool {
+	if r.Channel != channelId {
+		return false
+	}
+	return r.pattern == nil || r.pattern.MatchString(html.UnescapeString(v.Title))
+}
+
+// HandleEvent enqueues new uploads matching auto_download rules
+func (m *Manager) HandleEvent(e storage.Event) {
+	if e.Type != consts.EV_NEW_VIDEOS {
+		return
+	}
+	for _, r := range m.rules {
+		for _, v := range e.Videos {
+			if !r.matches(e.ChannelId, v) {
+				continue
+			}
+			if err := m.enqueue(v, e.ChannelId, r.Preset, r); err != nil {
+				log.Printf("auto download %s error: %s\n", v.Id, err.Error())
+			}
+		}
+	}
+}
+
+// remove oldest files downloaded by rule until keep_last and max_gb limits are met
+func (m *Manager) prune(r *rule) {
+	if r.KeepLast <= 0 && r.MaxGB <= 0 {
+		return
+	}
+
+	downloads, err := m.Storage.ReadDownloads()
+	if err != nil {
+		return
+	}
+
+	owned := make([]models.Download, 0)
+	for _, d := range downloads {
+		if d.Auto && d.Status == consts.DL_DONE && d.ChannelId == r.Channel && d.Preset == r.Preset {
+			owned = append(owned, d)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i].Finished.After(owned[j].Finished) })
+
+	maxSize := int64(r.MaxGB * (1 << 30))
+	var size int64
+	remove := make([]models.Download, 0)
+	for i, d := range owned {
+		size += d.Size
+		if (r.KeepLast > 0 && i >= r.KeepLast) || (maxSize > 0 && size > maxSize) {
+			remove = append(remove, d)
+		}
+	}
+	if len(remove) == 0 {
+		return
+	}
+
+	for _, d := range remove {
+		if err := os.Remove(d.Path); err != nil && !os.IsNotExist(err) {
+			log.Printf("remove %#v error: %s\n", d.Path, err.Error())
+		}
+	}
+	if err := m.Storage.RemoveDownloads(remove...); err != nil {
+		log.Printf("remove pruned downloads error: %s\n", err.Error())
+	}
+}
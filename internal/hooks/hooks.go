@@ -0,0 +1,176 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/su55y/yt_feed/internal/config"
+	"github.com/su55y/yt_feed/internal/consts"
+	"github.com/su55y/yt_feed/internal/player"
+	"github.com/su55y/yt_feed/internal/storage"
+)
+
+const (
+	timeout = 10 * time.Second
+	// hooks fired while queue is full are dropped
+	queueSize = 100
+)
+
+// Payload is posted as JSON to hook url, command hooks get the same
+// fields as YT_FEED_* env variables
+type Payload struct {
+	Event        string `json:"event"`
+	ChannelId    string `json:"channel_id"`
+	ChannelTitle string `json:"channel_title,omitempty"`
+	VideoId      string `json:"video_id,omitempty"`
+	VideoTitle   string `json:"video_title,omitempty"`
+	VideoURL     string `json:"video_url,omitempty"`
+	Error        string `json:"error,omitempty"`
+	OldTitle     string `json:"old_title,omitempty"`
+	NewTitle     string `json:"new_title,omitempty"`
+}
+
+type Runner struct {
+	Hooks  *config.HooksConfig
+	ctx    context.Context
+	client *http.Client
+	queue  chan call
+}
+
+type call struct {
+	hook    config.Hook
+	payload Payload
+}
+
+// New starts worker which fires hooks one by one in background, so slow
+// hooks never block updates. ctx cancels running and queued hooks
+func New(ctx context.Context, conf *config.HooksConfig) Runner {
+	r := Runner{
+		Hooks:  conf,
+		ctx:    ctx,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan call, queueSize),
+	}
+	go r.worker()
+	return r
+}
+
+// HandleEvent fires hooks configured for storage event
+func (r *Runner) HandleEvent(e storage.Event) {
+	switch e.Type {
+	case consts.EV_NEW_VIDEOS:
+		for _, v := range e.Videos {
+			r.fire(r.Hooks.OnNewVideo, Payload{
+				Event:        consts.HOOK_NEW_VIDEO,
+				ChannelId:    e.ChannelId,
+				ChannelTitle: v.ChannelTitle,
+				VideoId:      v.Id,
+				VideoTitle:   html.UnescapeString(v.Title),
+				VideoURL:     player.VideoURL(v.Id),
+			})
+		}
+	case consts.EV_UPDATE_FAILED:
+		p := Payload{Event: consts.HOOK_UPDATE_FAILED, ChannelId: e.ChannelId}
+		if e.Err != nil {
+			p.Error = e.Err.Error()
+		}
+		r.fire(r.Hooks.OnUpdateFailed, p)
+	case consts.EV_CHANNEL_RENAMED:
+		r.fire(r.Hooks.OnChannelRenamed, Payload{
+			Event:        consts.HOOK_CHANNEL_RENAMED,
+			ChannelId:    e.ChannelId,
+			ChannelTitle: e.NewTitle,
+			OldTitle:     e.OldTitle,
+			NewTitle:     e.NewTitle,
+		})
+	}
+}
+
+// queues hooks, they are fired by worker
+func (r *Runner) fire(hooks []config.Hook, p Payload) {
+	for _, h := range hooks {
+		if len(h.URL) == 0 && len(h.Command) == 0 {
+			continue
+		}
+		select {
+		case r.queue <- call{hook: h, payload: p}:
+		default:
+			log.Printf("%s hook dropped: queue is full\n", p.Event)
+		}
+	}
+}
+
+func (r *Runner) worker() {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case c := <-r.queue:
+			var err error
+			if len(c.hook.URL) > 0 {
+				err = r.post(r.ctx, c.hook.URL, c.payload)
+			} else {
+				err = run(r.ctx, c.hook.Command, c.payload)
+			}
+			if err != nil {
+				log.Printf("%s hook error: %s\n", c.payload.Event, err.Error())
+			}
+		}
+	}
+}
+
+func (r *Runner) post(ctx context.Context, url string, p Payload) error {
+	body, err := json.Marshal(&p)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("post to '%s' failed: %s", url, resp.Status)
+	}
+	return nil
+}
+
+func run(ctx context.Context, command []string, p Payload) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	c := exec.CommandContext(ctx, command[0], command[1:]...)
+	c.Env = append(os.Environ(),
+		"YT_FEED_EVENT="+p.Event,
+		"YT_FEED_CHANNEL_ID="+p.ChannelId,
+		"YT_FEED_CHANNEL_TITLE="+p.ChannelTitle,
+		"YT_FEED_VIDEO_ID="+p.VideoId,
+		"YT_FEED_VIDEO_TITLE="+p.VideoTitle,
+		"YT_FEED_VIDEO_URL="+p.VideoURL,
+		"YT_FEED_ERROR="+p.Error,
+		"YT_FEED_OLD_TITLE="+p.OldTitle,
+		"YT_FEED_NEW_TITLE="+p.NewTitle,
+	)
+
+	err := c.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("'%s' timed out", command[0])
+	}
+	return err
+}
@@ -0,0 +1,115 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/su55y/yt_feed/internal/config"
+	"github.com/su55y/yt_feed/internal/consts"
+	"github.com/su55y/yt_feed/internal/models"
+	"github.com/su55y/yt_feed/internal/storage"
+)
+
+func TestPostNewVideo(t *testing.T) {
+	received := make(chan Payload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("content type = %q, want application/json", ct)
+		}
+		var p Payload
+		if err := json.NewDecoder(req.Body).Decode(&p); err != nil {
+			t.Errorf("decode payload: %s", err)
+		}
+		received <- p
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := New(ctx, &config.HooksConfig{OnNewVideo: []config.Hook{{URL: srv.URL}}})
+	r.HandleEvent(storage.Event{
+		Type:      consts.EV_NEW_VIDEOS,
+		ChannelId: "UCchannel",
+		Videos: []models.Video{{
+			Id:           "dQw4w9WgXcQ",
+			Title:        "Tom &amp; Jerry",
+			ChannelTitle: "Channel",
+		}},
+	})
+
+	want := Payload{
+		Event:        consts.HOOK_NEW_VIDEO,
+		ChannelId:    "UCchannel",
+		ChannelTitle: "Channel",
+		VideoId:      "dQw4w9WgXcQ",
+		VideoTitle:   "Tom & Jerry",
+		VideoURL:     "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+	}
+	select {
+	case got := <-received:
+		if got != want {
+			t.Errorf("payload = %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hook was not posted")
+	}
+}
+
+func TestRunEnv(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	out := filepath.Join(t.TempDir(), "env")
+	p := Payload{
+		Event:        consts.HOOK_CHANNEL_RENAMED,
+		ChannelId:    "UCchannel",
+		ChannelTitle: "New",
+		OldTitle:     "Old",
+		NewTitle:     "New",
+	}
+	if err := run(context.Background(), []string{"sh", "-c", "env > " + out}, p); err != nil {
+		t.Fatalf("run: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := make(map[string]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok && strings.HasPrefix(k, "YT_FEED_") {
+			env[k] = v
+		}
+	}
+	for k, v := range map[string]string{
+		"YT_FEED_EVENT":         consts.HOOK_CHANNEL_RENAMED,
+		"YT_FEED_CHANNEL_ID":    "UCchannel",
+		"YT_FEED_CHANNEL_TITLE": "New",
+		"YT_FEED_OLD_TITLE":     "Old",
+		"YT_FEED_NEW_TITLE":     "New",
+		"YT_FEED_VIDEO_ID":      "",
+	} {
+		if got, ok := env[k]; !ok || got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not available")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := run(ctx, []string{"sleep", "5"}, Payload{}); err == nil {
+		t.Error("canceled hook succeeded")
+	}
+}
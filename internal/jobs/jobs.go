@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+)
+
+// Result of finished job
+type Result struct {
+	Key string
+	Err error
+}
+
+// Registry runs background jobs, only one job with the same key can run
+// at once. Results are sent to Done channel in order of completion
+type Registry struct {
+	mu      *sync.Mutex
+	running map[string]bool
+	done    chan Result
+}
+
+func New() Registry {
+	return Registry{
+		mu:      &sync.Mutex{},
+		running: make(map[string]bool, 0),
+		done:    make(chan Result),
+	}
+}
+
+// Start runs fn in background, returns false when job with the same key
+// is still running
+func (r *Registry) Start(ctx context.Context, key string, fn func(context.Context) error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running[key] {
+		return false
+	}
+	r.running[key] = true
+
+	go func() {
+		err := fn(ctx)
+		r.mu.Lock()
+		delete(r.running, key)
+		r.mu.Unlock()
+		r.done <- Result{Key: key, Err: err}
+	}()
+	return true
+}
+
+func (r *Registry) Running(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running[key]
+}
+
+// Done receives results of finished jobs
+func (r *Registry) Done() <-chan Result {
+	return r.done
+}
@@ -13,16 +13,25 @@ type Playlist struct {
 type Video struct {
 	Id            string               `json:"id"`
 	Title         string               `json:"title"`
+	ChannelId     string               `json:"channel_id"`
+	ChannelTitle  string               `json:"channel_title"`
 	Thumbnails    map[string]Thumbnail `json:"thumb"`
 	ThumbnailPath string               `json:"thumb_path"`
 }
 
+// private videos are listed in playlists but can't be played
+func (v Video) Private() bool {
+	return v.Title == "Private video"
+}
+
 type Channel struct {
 	Id            string               `json:"id"`
 	Title         string               `json:"title"`
 	Thumbnails    map[string]Thumbnail `json:"thumb"`
 	ThumbnailPath string               `json:"thumb_path"`
 	LastUpdate    time.Time            `json:"last_update"`
+	// uploads playlist id never changes, so it's fetched once with channel
+	UploadsPlaylistId string `json:"uploads_id"`
 }
 
 type Thumbnail struct {
@@ -30,3 +39,37 @@ type Thumbnail struct {
 	Height int    `json:"height"`
 	URL    string `json:"url"`
 }
+
+type HistoryEntry struct {
+	Position float64   `json:"position"`
+	Duration float64   `json:"duration"`
+	Watched  bool      `json:"watched"`
+	Updated  time.Time `json:"updated"`
+}
+
+// resume point is offered only after first few seconds of unwatched video
+func (h HistoryEntry) Resumable() bool {
+	return !h.Watched && h.Position >= 10
+}
+
+type Download struct {
+	VideoId   string  `json:"video_id"`
+	Title     string  `json:"title"`
+	ChannelId string  `json:"channel_id"`
+	Preset    string  `json:"preset"`
+	Status    string  `json:"status"`
+	Progress  float64 `json:"progress"`
+	Path      string  `json:"path"`
+	Size      int64   `json:"size"`
+	Error     string  `json:"error,omitempty"`
+	// downloaded by auto_download rule, such files are pruned by rule limits
+	Auto bool `json:"auto"`
+	// time when download was finished or failed
+	Finished time.Time `json:"finished"`
+}
+
+// CacheMeta describes last fetch of cached file
+type CacheMeta struct {
+	ETag    string    `json:"etag"`
+	Fetched time.Time `json:"fetched"`
+}
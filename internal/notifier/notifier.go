@@ -0,0 +1,217 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/su55y/yt_feed/internal/config"
+	"github.com/su55y/yt_feed/internal/consts"
+	"github.com/su55y/yt_feed/internal/models"
+	"github.com/su55y/yt_feed/internal/storage"
+)
+
+const (
+	// max video titles listed in notification body
+	maxTitles = 5
+	// events received while queue is full are dropped
+	queueSize = 100
+)
+
+var defaultCommand = []string{
+	"notify-send", "-a", consts.APP_NAME, "-i", "{icon}", "{summary}", "{body}",
+}
+
+type Notification struct {
+	Summary string
+	// body may contain basic markup, titles are html escaped
+	Body string
+	// absolute path to the icon, may be empty
+	Icon string
+}
+
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// New returns notifier selected by notifications.backend
+func New(conf *config.NotificationsConfig) (Notifier, error) {
+	switch conf.Backend {
+	case "", consts.NOTIFY_COMMAND:
+		command := conf.Command
+		if len(command) == 0 {
+			command = defaultCommand
+		}
+		return Command{Command: command}, nil
+	case consts.NOTIFY_DBUS:
+		return DBus{}, nil
+	}
+	return nil, fmt.Errorf("unknown notifications backend '%s'", conf.Backend)
+}
+
+// Command runs command template with {summary}, {body} and {icon} placeholders
+type Command struct {
+	Command []string
+}
+
+func (c Command) Notify(n Notification) error {
+	r := strings.NewReplacer("{summary}", n.Summary, "{body}", n.Body, "{icon}", n.Icon)
+	args := make([]string, 0, len(c.Command))
+	for _, a := range c.Command {
+		args = append(args, r.Replace(a))
+	}
+	return exec.Command(args[0], args[1:]...).Run()
+}
+
+// DBus calls org.freedesktop.Notifications.Notify on session bus
+type DBus struct{}
+
+func (DBus) Notify(n Notification) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return err
+	}
+
+	hints := map[string]dbus.Variant{}
+	if len(n.Icon) > 0 {
+		hints["image-path"] = dbus.MakeVariant(n.Icon)
+	}
+	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	call := obj.Call(
+		"org.freedesktop.Notifications.Notify", 0,
+		consts.APP_NAME, uint32(0), n.Icon, n.Summary, n.Body,
+		[]string{}, hints, int32(-1),
+	)
+	return call.Err
+}
+
+// Listener notifies about new videos found by any update
+type Listener struct {
+	Notifier      Notifier
+	Notifications *config.NotificationsConfig
+	Storage       *storage.Storage
+	ctx           context.Context
+	queue         chan storage.Event
+}
+
+// NewListener starts worker which sends notifications one by one in
+// background, so updates never wait for them. ctx stops the worker
+func NewListener(
+	ctx context.Context,
+	n Notifier,
+	conf *config.NotificationsConfig,
+	stor *storage.Storage,
+) Listener {
+	l := Listener{
+		Notifier:      n,
+		Notifications: conf,
+		Storage:       stor,
+		ctx:           ctx,
+		queue:         make(chan storage.Event, queueSize),
+	}
+	go l.worker()
+	return l
+}
+
+// HandleEvent queues EV_NEW_VIDEOS events, they are sent by worker
+func (l *Listener) HandleEvent(e storage.Event) {
+	if e.Type != consts.EV_NEW_VIDEOS || len(e.Videos) == 0 {
+		return
+	}
+	select {
+	case l.queue <- e:
+	default:
+		log.Printf("notification of %s new videos dropped: queue is full\n", e.ChannelId)
+	}
+}
+
+func (l *Listener) worker() {
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case e := <-l.queue:
+			channels, err := l.Storage.CachedChannels()
+			if err != nil {
+				channels = make(map[string]models.Channel, 0)
+			}
+			// thumbnails are fetched lazily, newest video one is shown in
+			// notification
+			if v := e.Videos[0]; len(v.ThumbnailPath) > 0 {
+				l.Storage.Service.FetchThumbnails(l.ctx, map[string]map[string]models.Thumbnail{
+					v.ThumbnailPath: v.Thumbnails,
+				})
+			}
+			NotifyNewVideos(l.Notifier, l.Notifications, channels, map[string][]models.Video{
+				e.ChannelId: e.Videos,
+			})
+		}
+	}
+}
+
+// NotifyNewVideos sends one notification per channel with new videos,
+// muted channels are skipped
+func NotifyNewVideos(
+	n Notifier,
+	conf *config.NotificationsConfig,
+	channels map[string]models.Channel,
+	added map[string][]models.Video,
+) {
+	muted := make(map[string]bool, len(conf.Mute))
+	for _, id := range conf.Mute {
+		muted[id] = true
+	}
+
+	for channelId, videos := range added {
+		if muted[channelId] || len(videos) == 0 {
+			continue
+		}
+		c := channels[channelId]
+		if err := n.Notify(newVideosNotification(c, videos)); err != nil {
+			log.Printf("notify %s new videos error: %s\n", channelId, err.Error())
+		}
+	}
+}
+
+func newVideosNotification(c models.Channel, videos []models.Video) Notification {
+	summary := fmt.Sprintf("%s: new video", html.UnescapeString(c.Title))
+	if len(videos) > 1 {
+		summary = fmt.Sprintf("%s: %d new videos", html.UnescapeString(c.Title), len(videos))
+	}
+
+	titles := make([]string, 0, maxTitles)
+	for i, v := range videos {
+		if i == maxTitles {
+			titles = append(titles, fmt.Sprintf("and %d more", len(videos)-maxTitles))
+			break
+		}
+		titles = append(titles, v.Title)
+	}
+
+	// prefer thumbnail of the newest video, fallback to channel avatar
+	icon := ""
+	if exists(videos[0].ThumbnailPath) {
+		icon = videos[0].ThumbnailPath
+	} else if exists(c.ThumbnailPath) {
+		icon = c.ThumbnailPath
+	}
+
+	return Notification{
+		Summary: summary,
+		Body:    strings.Join(titles, "\n"),
+		Icon:    icon,
+	}
+}
+
+func exists(path string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
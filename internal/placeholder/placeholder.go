@@ -0,0 +1,218 @@
+// Package placeholder generates icons shown instead of missing thumbnails:
+// coloured initials for channels and play glyph for videos and playlists.
+// Generated icons are cached as PNG in directory set by SetDir
+package placeholder
+
+import (
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/su55y/yt_feed/pkg/fsutil"
+)
+
+const (
+	size = 64
+	// glyph pixel size in icon pixels
+	scale = 4
+)
+
+var (
+	mu  sync.Mutex
+	dir string
+
+	background = color.NRGBA{0x3a, 0x3a, 0x3a, 0xff}
+	foreground = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// SetDir sets directory of cached placeholders, placeholders are disabled
+// until it's set
+func SetDir(d string) {
+	mu.Lock()
+	defer mu.Unlock()
+	dir = d
+}
+
+// Channel returns path of initials placeholder, background colour is
+// derived from channel id. Empty path is returned on error
+func Channel(id, title string) string {
+	letters := initials(title)
+	hue := hash(id) % 360
+	return cached(fmt.Sprintf("channel_%s_%d.png", letters, hue), func() image.Image {
+		img := image.NewNRGBA(image.Rect(0, 0, size, size))
+		fillCircle(img, hsl(float64(hue), 0.45, 0.45))
+		drawText(img, letters)
+		return img
+	})
+}
+
+// Video returns path of play glyph placeholder, used for videos and playlists
+func Video() string {
+	return cached("video.png", func() image.Image {
+		img := image.NewNRGBA(image.Rect(0, 0, size, size))
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				img.SetNRGBA(x, y, background)
+			}
+		}
+		drawPlay(img)
+		return img
+	})
+}
+
+// returns path of cached icon, icon is drawn when it's missing
+func cached(name string, draw func() image.Image) string {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dir) == 0 {
+		return ""
+	}
+
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		log.Printf("create placeholders dir error: %s\n", err.Error())
+		return ""
+	}
+
+	err := fsutil.WriteFileAtomic(path, func(w io.Writer) error {
+		return png.Encode(w, draw())
+	})
+	if err != nil {
+		log.Printf("save placeholder %s error: %s\n", name, err.Error())
+		return ""
+	}
+	return path
+}
+
+// first letters of the first two words, '?' when title has no known letters
+func initials(title string) string {
+	letters := make([]rune, 0, 2)
+	for _, word := range strings.Fields(title) {
+		for _, r := range word {
+			r = unicode.ToUpper(r)
+			if _, ok := font[r]; ok {
+				letters = append(letters, r)
+				break
+			}
+		}
+		if len(letters) == 2 {
+			break
+		}
+	}
+	if len(letters) == 0 {
+		return "?"
+	}
+	return string(letters)
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func fillCircle(img *image.NRGBA, c color.NRGBA) {
+	center := float64(size) / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			d := math.Hypot(float64(x)+0.5-center, float64(y)+0.5-center)
+			coverage := math.Max(0, math.Min(1, center-d+0.5))
+			if coverage > 0 {
+				img.SetNRGBA(x, y, color.NRGBA{c.R, c.G, c.B, uint8(math.Round(255 * coverage))})
+			}
+		}
+	}
+}
+
+// draws centered text with scaled bitmap font
+func drawText(img *image.NRGBA, text string) {
+	runes := []rune(text)
+	width := (len(runes)*(glyphWidth+1) - 1) * scale
+	left := (size - width) / 2
+	top := (size - glyphHeight*scale) / 2
+	for i, r := range runes {
+		glyph := font[r]
+		x0 := left + i*(glyphWidth+1)*scale
+		for row := 0; row < glyphHeight; row++ {
+			for col := 0; col < glyphWidth; col++ {
+				if glyph[row]&(1<<(glyphWidth-1-col)) == 0 {
+					continue
+				}
+				for dy := 0; dy < scale; dy++ {
+					for dx := 0; dx < scale; dx++ {
+						img.SetNRGBA(x0+col*scale+dx, top+row*scale+dy, foreground)
+					}
+				}
+			}
+		}
+	}
+}
+
+// draws right-pointing triangle in the middle of icon
+func drawPlay(img *image.NRGBA) {
+	const (
+		height = size / 2
+		width  = height * 7 / 8
+	)
+	left := (size-width)/2 + 2
+	top := (size - height) / 2
+	for y := 0; y < height; y++ {
+		// half width of triangle at this row
+		dist := float64(y) + 0.5 - float64(height)/2
+		if dist < 0 {
+			dist = -dist
+		}
+		span := float64(width) * (1 - 2*dist/float64(height))
+		for x := 0; x < width; x++ {
+			coverage := math.Max(0, math.Min(1, span-float64(x)))
+			if coverage > 0 {
+				c := blend(background, foreground, coverage)
+				img.SetNRGBA(left+x, top+y, c)
+			}
+		}
+	}
+}
+
+func blend(a, b color.NRGBA, t float64) color.NRGBA {
+	mix := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x)*(1-t) + float64(y)*t))
+	}
+	return color.NRGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xff}
+}
+
+// converts hue in degrees, saturation and lightness to colour
+func hsl(h, s, l float64) color.NRGBA {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	to8 := func(v float64) uint8 { return uint8(math.Round((v + m) * 255)) }
+	return color.NRGBA{to8(r), to8(g), to8(b), 0xff}
+}
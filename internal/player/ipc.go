@@ -0,0 +1,69 @@
+package player
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// mpv JSON IPC message, either event or command reply
+type ipcMessage struct {
+	Event string          `json:"event"`
+	Id    int             `json:"id"`
+	Name  string          `json:"name"`
+	Data  json.RawMessage `json:"data"`
+	// end-file reason: eof, stop, quit, error...
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+}
+
+type ipcClient struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+// connect to mpv socket, retry until mpv creates it or timeout is reached
+func dialIPC(path string, timeout time.Duration) (*ipcClient, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			scanner := bufio.NewScanner(conn)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			return &ipcClient{conn: conn, scanner: scanner}, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (c *ipcClient) command(args ...interface{}) error {
+	b, err := json.Marshal(map[string]interface{}{"command": args})
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(append(b, '\n'))
+	return err
+}
+
+// blocks until next message, returns error when mpv closes the socket
+func (c *ipcClient) next() (ipcMessage, error) {
+	var msg ipcMessage
+	for c.scanner.Scan() {
+		if err := json.Unmarshal(c.scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		return msg, nil
+	}
+	if err := c.scanner.Err(); err != nil {
+		return msg, err
+	}
+	return msg, net.ErrClosed
+}
+
+func (c *ipcClient) close() error {
+	return c.conn.Close()
+}
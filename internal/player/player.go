@@ -0,0 +1,256 @@
+package player
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/su55y/yt_feed/internal/config"
+	"github.com/su55y/yt_feed/internal/consts"
+	"github.com/su55y/yt_feed/internal/models"
+)
+
+// how often playback position is stored while video is playing
+const saveInterval = 5 * time.Second
+
+// downloaded files are named '<title> [<id>].<ext>'
+var fileIdPattern = regexp.MustCompile(`\[([a-zA-Z0-9_-]{11})\]\.[a-z0-9]+$`)
+
+// observed properties ids
+const (
+	propPath = iota + 1
+	propTimePos
+	propDuration
+)
+
+// stores playback positions reported by mpv
+type Recorder interface {
+	SavePosition(videoId string, position, duration float64) error
+}
+
+type Player struct {
+	AppConfig *config.AppConfig
+	Recorder  Recorder
+}
+
+func New(conf *config.AppConfig, rec Recorder) Player {
+	return Player{AppConfig: conf, Recorder: rec}
+}
+
+// Play opens single video in mpv, several videos are passed as m3u playlist
+func (p *Player) Play(videos ...models.Video) error {
+	return p.PlayFrom(0, videos...)
+}
+
+// PlayFrom starts playback from given position in seconds. mpv is started
+// by detached `yt_feed play` process so playback is tracked even after
+// menu is closed. Private videos are skipped
+func (p *Player) PlayFrom(start float64, videos ...models.Video) error {
+	videos = Playable(videos)
+	var target string
+	switch len(videos) {
+	case 0:
+		return errors.New("nothing to play")
+	case 1:
+		target = VideoURL(videos[0].Id)
+	default:
+		path, err := p.writePlaylist(videos)
+		if err != nil {
+			return err
+		}
+		target = "--playlist=" + path
+	}
+
+	return p.detach(start, target)
+}
+
+// PlayFile opens downloaded file
+func (p *Player) PlayFile(path string) error {
+	return p.detach(0, path)
+}
+
+func (p *Player) detach(start float64, target string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	c := exec.Command(self, playArgs(start, target)...)
+	if err := c.Start(); err != nil {
+		log.Printf("player start error: %s\n", err.Error())
+		return err
+	}
+
+	// player lives longer than us, don't leave a zombie
+	go c.Wait()
+	return nil
+}
+
+// returns arguments of play command, target goes after "--" so mpv
+// options like --playlist are not parsed as play flags
+func playArgs(start float64, target string) []string {
+	return []string{consts.CMD_PLAY, fmt.Sprintf("-start=%g", start), "--", target}
+}
+
+// ParseArgs parses play command arguments into start position and
+// arguments passed to mpv
+func ParseArgs(args []string) (float64, []string, error) {
+	flags := flag.NewFlagSet(consts.CMD_PLAY, flag.ContinueOnError)
+	start := flags.Float64("start", 0, "start position in seconds")
+	if err := flags.Parse(args); err != nil {
+		return 0, nil, err
+	}
+	return *start, flags.Args(), nil
+}
+
+// Run starts mpv with given arguments and stores playback positions
+// received over mpv JSON IPC until mpv exits
+func (p *Player) Run(start float64, args ...string) error {
+	socket := filepath.Join(
+		p.AppConfig.CachePath,
+		fmt.Sprintf("mpv%d.sock", os.Getpid()),
+	)
+	mpvArgs := []string{"--input-ipc-server=" + socket}
+	if start > 0 {
+		mpvArgs = append(mpvArgs, fmt.Sprintf("--start=%g", start))
+	}
+
+	c := exec.Command("mpv", append(mpvArgs, args...)...)
+	if err := c.Start(); err != nil {
+		log.Printf("mpv start error: %s\n", err.Error())
+		return err
+	}
+	defer os.Remove(socket)
+
+	client, err := dialIPC(socket, 10*time.Second)
+	if err != nil {
+		log.Printf("mpv ipc connect error: %s\n", err.Error())
+		return c.Wait()
+	}
+	defer client.close()
+
+	p.track(client)
+	return c.Wait()
+}
+
+func (p *Player) track(c *ipcClient) {
+	for id, name := range map[int]string{
+		propPath:     "path",
+		propTimePos:  "time-pos",
+		propDuration: "duration",
+	} {
+		if err := c.command("observe_property", id, name); err != nil {
+			log.Printf("mpv observe %s error: %s\n", name, err.Error())
+			return
+		}
+	}
+
+	var videoId string
+	var position, duration float64
+	var lastSave time.Time
+	save := func() {
+		if len(videoId) == 0 || position <= 0 || p.Recorder == nil {
+			return
+		}
+		if err := p.Recorder.SavePosition(videoId, position, duration); err != nil {
+			log.Printf("save %s position error: %s\n", videoId, err.Error())
+		}
+		lastSave = time.Now()
+	}
+
+	for {
+		msg, err := c.next()
+		if err != nil {
+			save()
+			return
+		}
+
+		switch msg.Event {
+		case "property-change":
+			switch msg.Id {
+			case propPath:
+				var path string
+				if json.Unmarshal(msg.Data, &path) == nil && len(path) > 0 {
+					save()
+					videoId, position, duration = idFromPath(path), 0, 0
+				}
+			case propTimePos:
+				json.Unmarshal(msg.Data, &position)
+				if time.Since(lastSave) >= saveInterval {
+					save()
+				}
+			case propDuration:
+				json.Unmarshal(msg.Data, &duration)
+			}
+		case "end-file":
+			if msg.Reason == "eof" && duration > 0 {
+				position = duration
+			}
+			save()
+		}
+	}
+}
+
+// Playable returns videos without private ones
+func Playable(videos []models.Video) []models.Video {
+	playable := make([]models.Video, 0, len(videos))
+	for _, v := range videos {
+		if !v.Private() {
+			playable = append(playable, v)
+		}
+	}
+	return playable
+}
+
+func (p *Player) writePlaylist(videos []models.Video) (string, error) {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, v := range videos {
+		fmt.Fprintf(&b, "#EXTINF:-1,%s\n%s\n", html.UnescapeString(v.Title), VideoURL(v.Id))
+	}
+
+	path := filepath.Join(p.AppConfig.CachePath, consts.PLAYLIST_FILE_NAME)
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		log.Printf("write %#v playlist error: %s\n", path, err.Error())
+		return "", err
+	}
+	return path, nil
+}
+
+func VideoURL(id string) string {
+	return "https://www.youtube.com/watch?v=" + id
+}
+
+// returns video id from youtube url or downloaded file name
+func idFromPath(rawURL string) string {
+	if match := fileIdPattern.FindStringSubmatch(filepath.Base(rawURL)); match != nil {
+		return match[1]
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	if u.Host == "youtu.be" {
+		return strings.TrimPrefix(u.Path, "/")
+	}
+	return u.Query().Get("v")
+}
+
+// formats seconds as m:ss or h:mm:ss
+func FormatPosition(seconds float64) string {
+	s := int(seconds)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s%3600/60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
@@ -0,0 +1,183 @@
+package player
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/su55y/yt_feed/internal/config"
+	"github.com/su55y/yt_feed/internal/storage"
+)
+
+type savedPosition struct {
+	videoId            string
+	position, duration float64
+}
+
+type fakeRecorder struct {
+	saved []savedPosition
+}
+
+func (r *fakeRecorder) SavePosition(videoId string, position, duration float64) error {
+	r.saved = append(r.saved, savedPosition{videoId, position, duration})
+	return nil
+}
+
+// serves scripted mpv messages over unix socket after observe commands are
+// received, connection is closed when script ends
+func fakeMPV(t *testing.T, script ...string) *ipcClient {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "mpv.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix socket is not available: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewScanner(conn)
+		for i := 0; i < 3 && r.Scan(); i++ {
+			// observe_property commands
+		}
+		for _, line := range script {
+			if _, err := conn.Write([]byte(line + "\n")); err != nil {
+				return
+			}
+		}
+	}()
+
+	c, err := dialIPC(socket, time.Second)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	t.Cleanup(func() { c.close() })
+	return c
+}
+
+func TestTrack(t *testing.T) {
+	c := fakeMPV(t,
+		`{"event":"property-change","id":1,"name":"path","data":"https://www.youtube.com/watch?v=AAAAAAAAAAA"}`,
+		`{"event":"property-change","id":3,"name":"duration","data":100}`,
+		// first position is saved at once
+		`{"event":"property-change","id":2,"name":"time-pos","data":50}`,
+		// throttled by save interval
+		`{"event":"property-change","id":2,"name":"time-pos","data":60}`,
+		`not a json line`,
+		// path change saves previous video
+		`{"event":"property-change","id":1,"name":"path","data":"/videos/title [BBBBBBBBBBB].mkv"}`,
+		`{"event":"property-change","id":3,"name":"duration","data":200}`,
+		`{"event":"property-change","id":2,"name":"time-pos","data":10}`,
+		// finished video is saved at its duration
+		`{"event":"end-file","reason":"eof"}`,
+	)
+	rec := &fakeRecorder{}
+	p := Player{Recorder: rec}
+	p.track(c)
+
+	want := []savedPosition{
+		{"AAAAAAAAAAA", 50, 100},
+		{"AAAAAAAAAAA", 60, 100},
+		{"BBBBBBBBBBB", 200, 200},
+		// saved once more when socket is closed
+		{"BBBBBBBBBBB", 200, 200},
+	}
+	if !reflect.DeepEqual(rec.saved, want) {
+		t.Errorf("saved = %v, want %v", rec.saved, want)
+	}
+}
+
+func TestTrackStopped(t *testing.T) {
+	c := fakeMPV(t,
+		`{"event":"property-change","id":1,"name":"path","data":"https://youtu.be/AAAAAAAAAAA"}`,
+		`{"event":"property-change","id":3,"name":"duration","data":100}`,
+		`{"event":"property-change","id":2,"name":"time-pos","data":30}`,
+		// stopped video keeps its position
+		`{"event":"end-file","reason":"stop"}`,
+	)
+	rec := &fakeRecorder{}
+	p := Player{Recorder: rec}
+	p.track(c)
+
+	for _, s := range rec.saved {
+		if s.position != 30 {
+			t.Errorf("saved position %g, want 30", s.position)
+		}
+	}
+	if len(rec.saved) == 0 {
+		t.Error("position was not saved")
+	}
+}
+
+func TestTrackWatchedPercent(t *testing.T) {
+	stor := storage.New(&config.AppConfig{CachePath: t.TempDir(), WatchedPercent: 90}, nil)
+	c := fakeMPV(t,
+		`{"event":"property-change","id":1,"name":"path","data":"https://www.youtube.com/watch?v=AAAAAAAAAAA"}`,
+		`{"event":"property-change","id":3,"name":"duration","data":100}`,
+		`{"event":"property-change","id":2,"name":"time-pos","data":85}`,
+		`{"event":"property-change","id":1,"name":"path","data":"https://www.youtube.com/watch?v=BBBBBBBBBBB"}`,
+		`{"event":"property-change","id":3,"name":"duration","data":100}`,
+		`{"event":"property-change","id":2,"name":"time-pos","data":95}`,
+	)
+	p := Player{Recorder: &stor}
+	p.track(c)
+
+	history, err := stor.ReadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := history["AAAAAAAAAAA"]; e.Watched || e.Position != 85 {
+		t.Errorf("below threshold entry = %+v, want unwatched at 85", e)
+	}
+	if e := history["BBBBBBBBBBB"]; !e.Watched || e.Position != 95 {
+		t.Errorf("above threshold entry = %+v, want watched at 95", e)
+	}
+}
+
+func TestIdFromPath(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/watch?list=PL123&v=dQw4w9WgXcQ&t=42", "dQw4w9WgXcQ"},
+		{"https://youtu.be/dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"/home/user/videos/Some title [dQw4w9WgXcQ].mkv", "dQw4w9WgXcQ"},
+		{"/home/user/videos/Title [with] brackets [a-b_c123456].webm", "a-b_c123456"},
+		{"/home/user/videos/no id.mkv", ""},
+		{"https://example.com/video.mp4", ""},
+		{"", ""},
+	} {
+		if got := idFromPath(tc.path); got != tc.want {
+			t.Errorf("idFromPath(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestPlayArgs(t *testing.T) {
+	for _, tc := range []struct {
+		start  float64
+		target string
+	}{
+		{0, "--playlist=/cache/queue.m3u"},
+		{42.5, "https://www.youtube.com/watch?v=dQw4w9WgXcQ"},
+		{0, "/home/user/videos/-title [dQw4w9WgXcQ].mkv"},
+	} {
+		args := playArgs(tc.start, tc.target)
+		start, rest, err := ParseArgs(args[1:])
+		if err != nil {
+			t.Errorf("ParseArgs(%q): %s", args, err)
+			continue
+		}
+		if start != tc.start || !reflect.DeepEqual(rest, []string{tc.target}) {
+			t.Errorf("ParseArgs(%q) = %g, %q, want %g, %q", args, start, rest, tc.start, []string{tc.target})
+		}
+	}
+}
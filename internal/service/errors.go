@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	maxAttempts = 4
+	baseDelay   = 500 * time.Millisecond
+)
+
+// api error kinds, check them with errors.Is
+var (
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrRateLimited   = errors.New("rate limit exceeded")
+	ErrKeyInvalid    = errors.New("api key invalid")
+	ErrForbidden     = errors.New("access forbidden")
+	ErrNotFound      = errors.New("not found")
+	ErrTransient     = errors.New("temporary api error")
+)
+
+// APIError is classified googleapi.Error or network error
+type APIError struct {
+	Kind   error
+	Reason string
+	Err    error
+}
+
+func (e *APIError) Error() string {
+	if len(e.Reason) > 0 {
+		return fmt.Sprintf("%s (%s): %s", e.Kind.Error(), e.Reason, e.Err.Error())
+	}
+	return fmt.Sprintf("%s: %s", e.Kind.Error(), e.Err.Error())
+}
+
+func (e *APIError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// classify wraps api and network errors into APIError, other errors are
+// returned as is
+func classify(err error) error {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		var nerr net.Error
+		if errors.As(err, &nerr) {
+			return &APIError{Kind: ErrTransient, Err: err}
+		}
+		return err
+	}
+
+	reason := ""
+	if len(gerr.Errors) > 0 {
+		reason = gerr.Errors[0].Reason
+	}
+
+	var kind error
+	switch reason {
+	case "quotaExceeded", "dailyLimitExceeded":
+		kind = ErrQuotaExceeded
+	case "rateLimitExceeded", "userRateLimitExceeded":
+		kind = ErrRateLimited
+	case "keyInvalid", "keyExpired":
+		kind = ErrKeyInvalid
+	case "forbidden", "accessNotConfigured", "ipRefererBlocked":
+		kind = ErrForbidden
+	case "notFound", "channelNotFound", "playlistNotFound":
+		kind = ErrNotFound
+	}
+
+	if kind == nil {
+		switch {
+		case strings.Contains(gerr.Message, "API key not valid"):
+			kind = ErrKeyInvalid
+		case gerr.Code == http.StatusNotFound:
+			kind = ErrNotFound
+		case gerr.Code == http.StatusForbidden:
+			kind = ErrForbidden
+		case gerr.Code == http.StatusTooManyRequests:
+			kind = ErrRateLimited
+		case gerr.Code >= 500:
+			kind = ErrTransient
+		default:
+			return err
+		}
+	}
+	return &APIError{Kind: kind, Reason: reason, Err: err}
+}
+
+func retryable(err error) bool {
+	return errors.Is(err, ErrTransient) || errors.Is(err, ErrRateLimited)
+}
+
+// do spends quota and runs api call, transient errors are retried with
+// jittered exponential backoff
+func (s *Service) do(
+	ctx context.Context,
+	call string,
+	essential bool,
+	fn func() error,
+) error {
+	delay := baseDelay
+	for attempt := 1; ; attempt++ {
+		if err := s.Quota.spend(call, listCost, essential); err != nil {
+			return err
+		}
+
+		err := fn()
+		if err == nil || googleapi.IsNotModified(err) {
+			return err
+		}
+
+		err = classify(err)
+		if !retryable(err) || attempt == maxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		sleep := delay + time.Duration(rand.Int63n(int64(delay)))
+		log.Printf("%s attempt %d failed, retry in %s: %s\n", call, attempt, sleep, err.Error())
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// UserMessage returns short actionable description of update error
+func UserMessage(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrQuotaExceeded):
+		return "quota exceeded, resets at 00:00 PT" + untilReset()
+	case errors.Is(err, ErrQuotaBudget):
+		return "quota budget exceeded, resets at 00:00 PT" + untilReset()
+	case errors.Is(err, ErrQuotaReserve):
+		return "quota budget nearly spent, playlists refresh deferred"
+	case errors.Is(err, ErrKeyInvalid):
+		return "api key is invalid, check api_key in config"
+	case errors.Is(err, ErrForbidden):
+		return "api access forbidden, check that YouTube Data API is enabled for the key"
+	case errors.Is(err, ErrNotFound):
+		return "channel or playlist not found"
+	case errors.Is(err, ErrRateLimited):
+		return "too many requests, try again in a minute"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "update timed out, try again later or increase channel_timeout"
+	case errors.Is(err, context.Canceled):
+		return "update canceled"
+	case errors.Is(err, ErrTransient):
+		return "youtube api is unavailable, try again later"
+	}
+	return err.Error()
+}
+
+func untilReset() string {
+	now := time.Now().In(quotaLocation)
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, quotaLocation)
+	return fmt.Sprintf(" (in %s)", midnight.Sub(now).Truncate(time.Minute))
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/su55y/yt_feed/internal/cachefile"
+	"github.com/su55y/yt_feed/internal/config"
+)
+
+const (
+	quotaFile = "quota.json"
+
+	defaultBudget  = 10000
+	defaultReserve = 0.9
+
+	// every list call used here costs one unit
+	listCost = 1
+)
+
+var (
+	ErrQuotaBudget  = errors.New("daily quota budget exceeded")
+	ErrQuotaReserve = errors.New("daily quota budget is nearly exhausted, non-essential call deferred")
+)
+
+// QuotaError reports whether call was refused because quota is exhausted
+func QuotaError(err error) bool {
+	return errors.Is(err, ErrQuotaBudget) || errors.Is(err, ErrQuotaReserve) ||
+		errors.Is(err, ErrQuotaExceeded)
+}
+
+// daily quota resets at midnight Pacific Time
+var quotaLocation = loadQuotaLocation()
+
+type QuotaUsage struct {
+	// date in Pacific Time, YYYY-MM-DD
+	Date  string           `json:"date"`
+	Units int64            `json:"units"`
+	Calls map[string]int64 `json:"calls"`
+}
+
+// usage spent by this instance is written at most this often, see Flush
+const flushInterval = 10 * time.Second
+
+// Quota tracks estimated API units spent today. Spent units are kept in
+// memory and written periodically, stored usage is merged with usage of
+// other instances on every write
+type Quota struct {
+	path    string
+	budget  int64
+	reserve float64
+	mu      sync.Mutex
+	// usage of all instances as it was stored on last flush
+	stored QuotaUsage
+	// units spent since last flush
+	pending QuotaUsage
+	flushed time.Time
+}
+
+func newQuota(conf *config.AppConfig) *Quota {
+	q := &Quota{
+		path:    filepath.Join(conf.CachePath, quotaFile),
+		budget:  conf.Quota.Budget,
+		reserve: conf.Quota.Reserve,
+		pending: QuotaUsage{Calls: make(map[string]int64, 0)},
+	}
+	if q.budget <= 0 {
+		q.budget = defaultBudget
+	}
+	if q.reserve <= 0 || q.reserve > 1 {
+		q.reserve = defaultReserve
+	}
+	return q
+}
+
+// spend checks budget and records units of the call, non-essential calls
+// are refused when usage reaches reserve part of the budget
+func (q *Quota) spend(call string, units int64, essential bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if time.Since(q.flushed) >= flushInterval {
+		q.flush()
+	}
+	used := current(q.stored).Units + q.pending.Units
+	if used+units > q.budget {
+		return ErrQuotaBudget
+	}
+	if !essential && float64(used+units) > float64(q.budget)*q.reserve {
+		return ErrQuotaReserve
+	}
+
+	q.pending.Units += units
+	q.pending.Calls[call] += units
+	return nil
+}
+
+// Flush writes units spent since last flush, should be called after
+// updates and before exit
+func (q *Quota) Flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.flush()
+}
+
+func (q *Quota) flush() {
+	q.flushed = time.Now()
+	if q.pending.Units == 0 {
+		// only refresh usage of other instances
+		q.stored = q.read()
+		return
+	}
+
+	var stored QuotaUsage
+	err := cachefile.Update(q.path, &stored, func() error {
+		usage := current(stored)
+		usage.Units += q.pending.Units
+		for call, units := range q.pending.Calls {
+			usage.Calls[call] += units
+		}
+		stored = usage
+		return nil
+	})
+	if err != nil {
+		// pending units are written with next flush
+		log.Printf("write quota usage error: %s\n", err.Error())
+		return
+	}
+	q.stored = stored
+	q.pending = QuotaUsage{Calls: make(map[string]int64, 0)}
+}
+
+// Usage returns today's usage and daily budget
+func (q *Quota) Usage() (int64, int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.read().Units + q.pending.Units, q.budget
+}
+
+func (q *Quota) String() string {
+	used, budget := q.Usage()
+	return fmt.Sprintf("quota %d/%d", used, budget)
+}
+
+func (q *Quota) read() QuotaUsage {
+	var stored QuotaUsage
+	if err := cachefile.Read(q.path, &stored); err != nil && !os.IsNotExist(err) {
+		log.Printf("read quota usage error: %s\n", err.Error())
+	}
+	return current(stored)
+}
+
+// returns stored usage if it's today's one, or empty usage otherwise
+func current(stored QuotaUsage) QuotaUsage {
+	today := time.Now().In(quotaLocation).Format("2006-01-02")
+	if stored.Date != today {
+		return QuotaUsage{Date: today, Calls: make(map[string]int64, 0)}
+	}
+	if stored.Calls == nil {
+		stored.Calls = make(map[string]int64, 0)
+	}
+	return stored
+}
+
+func loadQuotaLocation() *time.Location {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		return time.FixedZone("PST", -8*60*60)
+	}
+	return loc
+}
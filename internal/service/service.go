@@ -5,6 +5,7 @@ import (
 	"errors"
 	"html"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -12,13 +13,21 @@ import (
 	"github.com/su55y/yt_feed/internal/consts"
 	"github.com/su55y/yt_feed/internal/models"
 	"github.com/su55y/yt_feed/pkg/downloader"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
 )
 
+// max ids or results of single list request
+const maxIds = 50
+
+// returned for conditional requests when cached data is still fresh
+var ErrNotModified = errors.New("not modified")
+
 type Service struct {
 	YT        *youtube.Service
 	AppConfig *config.AppConfig
+	Quota     *Quota
 }
 
 func New(ctx context.Context, conf *config.AppConfig) Service {
@@ -29,161 +38,339 @@ func New(ctx context.Context, conf *config.AppConfig) Service {
 	return Service{
 		YT:        yt,
 		AppConfig: conf,
+		Quota:     newQuota(conf),
 	}
 }
 
-// Get channels list request and download thumbnails for them
-func (s *Service) GetChannels() (map[string]models.Channel, error) {
-	call := s.YT.Channels.List([]string{"snippet"}).
-		Id(strings.Join(s.AppConfig.Channels, ",")).
-		MaxResults(50)
-
-	res, err := call.Do()
-	if err != nil {
-		return nil, err
-	}
-
-	if res.Items == nil || len(res.Items) == 0 {
-		return nil, errors.New("get channels list request failed")
-	}
-
+// GetChannels requests channels by ids and downloads thumbnails for them,
+// ids are requested in batches of 50. Ids which are not found are missing
+// from result. Avatars of cached channels are downloaded again when their
+// url changed
+func (s *Service) GetChannels(
+	ctx context.Context,
+	ids []string,
+	cached map[string]models.Channel,
+) (map[string]models.Channel, error) {
 	channels := make(map[string]models.Channel, 0)
 	thumbnails := make(map[string]string, 0)
 
-	for _, c := range res.Items {
-		channelThumbnails := parseThumbnails(c.Snippet.Thumbnails)
-		path, url := s.chooseThumbnail(c.Id, channelThumbnails)
-		thumbnails[path] = url
-		channels[c.Id] = models.Channel{
-			Id:            c.Id,
-			Title:         c.Snippet.Title,
-			Thumbnails:    channelThumbnails,
-			ThumbnailPath: path,
+	for start := 0; start < len(ids); start += maxIds {
+		end := start + maxIds
+		if end > len(ids) {
+			end = len(ids)
+		}
+		call := s.YT.Channels.List([]string{"snippet", "contentDetails"}).
+			Id(strings.Join(ids[start:end], ",")).
+			MaxResults(maxIds)
+
+		var res *youtube.ChannelListResponse
+		err := s.do(ctx, "channels.list", true, func() (err error) {
+			res, err = call.Context(ctx).Do()
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range res.Items {
+			channelThumbnails := parseThumbnails(c.Snippet.Thumbnails)
+			path, url := s.chooseThumbnail(c.Id, s.AppConfig.ThumbSizes.Channels, channelThumbnails)
+			if old, ok := cached[c.Id]; ok {
+				s.removeStaleAvatar(old, url)
+			}
+			if len(url) > 0 {
+				thumbnails[path] = url
+			}
+			channels[c.Id] = models.Channel{
+				Id:                c.Id,
+				Title:             c.Snippet.Title,
+				Thumbnails:        channelThumbnails,
+				ThumbnailPath:     s.iconPath(path, true),
+				UploadsPlaylistId: UploadsId(c.Id, uploadsFromDetails(c.ContentDetails)),
+			}
 		}
 	}
 
-	if !s.AppConfig.ThumbOff {
-		downloader.DownloadAll(thumbnails)
+	if len(channels) == 0 {
+		return nil, errors.New("get channels list request failed")
 	}
 
+	s.downloadThumbnails(ctx, thumbnails, true)
+
 	return channels, nil
 }
 
-func (s *Service) GetUploads(channelId string) ([]models.Video, error) {
-	if updId, ok := s.getUploadsId(channelId); ok {
-		res, err := s.getPlaylistVideos(updId)
-		if err != nil {
-			return nil, err
+// avatar path doesn't depend on its url, so avatar and its icon are removed
+// when url changed and are downloaded again
+func (s *Service) removeStaleAvatar(old models.Channel, url string) {
+	if s.AppConfig.ThumbOff {
+		return
+	}
+	path, oldURL := s.chooseThumbnail(old.Id, s.AppConfig.ThumbSizes.Channels, old.Thumbnails)
+	if len(path) == 0 || oldURL == url {
+		return
+	}
+	for _, p := range []string{path, s.iconPath(path, true), old.ThumbnailPath} {
+		if len(p) == 0 {
+			continue
+		}
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("remove stale avatar %s error: %s\n", filepath.Base(p), err.Error())
 		}
+	}
+}
 
-		return s.parseVideos(res), nil
+// GetUploads returns latest videos of uploads playlist, see UploadsId.
+// Non-empty etag makes request conditional, ErrNotModified is returned
+// when uploads didn't change since etag was received
+func (s *Service) GetUploads(
+	ctx context.Context,
+	uploadsId, etag string,
+) ([]models.Video, string, error) {
+	res, err := s.getPlaylistVideos(ctx, uploadsId, etag, true)
+	if err != nil {
+		return nil, "", err
 	}
 
-	return nil, errors.New("can't get uploads it for channel " + channelId)
+	return s.parseVideos(res), res.Etag, nil
 }
 
-func (s *Service) GetVideos(playlistId string) ([]models.Video, error) {
-	res, err := s.getPlaylistVideos(playlistId)
+// GetVideos returns videos of playlist, etag works the same way as in
+// GetUploads
+func (s *Service) GetVideos(
+	ctx context.Context,
+	playlistId, etag string,
+) ([]models.Video, string, error) {
+	res, err := s.getPlaylistVideos(ctx, playlistId, etag, false)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return s.parseVideos(res), nil
+	return s.parseVideos(res), res.Etag, nil
 }
 
-func (s *Service) GetPlaylists(channelId string) ([]models.Playlist, error) {
-	res, err := s.getPlaylists(channelId)
+// GetPlaylists returns channel playlists without videos, they are requested
+// with GetVideos. Etag works the same way as in GetUploads
+func (s *Service) GetPlaylists(
+	ctx context.Context,
+	channelId, etag string,
+) ([]models.Playlist, string, error) {
+	res, err := s.getPlaylists(ctx, channelId, etag)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return s.parsePlaylists(res), nil
+	return s.parsePlaylists(res), res.Etag, nil
 }
 
-func (s *Service) getPlaylists(channelId string) (*youtube.PlaylistListResponse, error) {
-	call := s.YT.Playlists.List([]string{"snippet"}).
+// playlists refresh is non-essential and is deferred when quota is low.
+// contentDetails part is requested to get new etag when items count changes
+func (s *Service) getPlaylists(
+	ctx context.Context,
+	channelId, etag string,
+) (*youtube.PlaylistListResponse, error) {
+	call := s.YT.Playlists.List([]string{"snippet", "contentDetails"}).
 		ChannelId(channelId).
 		MaxResults(50)
-	return call.Do()
+	if len(etag) > 0 {
+		call.IfNoneMatch(etag)
+	}
+	var res *youtube.PlaylistListResponse
+	err := s.do(ctx, "playlists.list", false, func() (err error) {
+		res, err = call.Context(ctx).Do()
+		return
+	})
+	if googleapi.IsNotModified(err) {
+		return nil, ErrNotModified
+	}
+	return res, err
 }
 
 // return playlists slice by channel id
 func (s *Service) parsePlaylists(res *youtube.PlaylistListResponse) []models.Playlist {
-	thumbnails := make(map[string]string, 0)
 	playlists := []models.Playlist{}
 	for _, p := range res.Items {
 		playlistThumbnails := parseThumbnails(p.Snippet.Thumbnails)
-		path, url := s.chooseThumbnail(p.Id, playlistThumbnails)
-		thumbnails[path] = url
-		vidRes, err := s.getPlaylistVideos(p.Id)
-		if err != nil {
-			log.Printf("can't get videos for playlist %s", p.Id)
-			continue
-		}
-		videos := s.parseVideos(vidRes)
+		path, _ := s.chooseThumbnail(p.Id, s.AppConfig.ThumbSizes.Playlists, playlistThumbnails)
 		playlists = append(playlists, models.Playlist{
 			Id:            p.Id,
 			Title:         html.EscapeString(p.Snippet.Title),
-			Videos:        videos,
 			Thumbnails:    playlistThumbnails,
-			ThumbnailPath: path,
+			ThumbnailPath: s.iconPath(path, false),
 		})
 	}
 
-	downloader.DownloadAll(thumbnails)
 	return playlists
 }
 
+// video thumbnails are not downloaded here, see FetchThumbnails
 func (s *Service) parseVideos(res *youtube.PlaylistItemListResponse) []models.Video {
 	videos := make([]models.Video, 0)
-	thumbnails := make(map[string]string, 0)
 	for _, v := range res.Items {
 		videoThumbnails := parseThumbnails(v.Snippet.Thumbnails)
-		path, url := s.chooseThumbnail(v.Id, videoThumbnails)
-		thumbnails[path] = url
+		// keyed by video id, so the same video in uploads and playlists
+		// shares thumbnail
+		path, _ := s.chooseThumbnail(
+			v.Snippet.ResourceId.VideoId,
+			s.AppConfig.ThumbSizes.Videos,
+			videoThumbnails,
+		)
 		videos = append(videos, models.Video{
 			Id:            v.Snippet.ResourceId.VideoId,
 			Title:         html.EscapeString(v.Snippet.Title),
+			ChannelId:     v.Snippet.VideoOwnerChannelId,
+			ChannelTitle:  v.Snippet.VideoOwnerChannelTitle,
 			Thumbnails:    videoThumbnails,
-			ThumbnailPath: path,
+			ThumbnailPath: s.iconPath(path, false),
 		})
 	}
 
-	if !s.AppConfig.ThumbOff {
-		downloader.DownloadAll(thumbnails)
-	}
 	return videos
 }
 
 // returns latest playlist 50 videos
-func (s *Service) getPlaylistVideos(playlistId string) (*youtube.PlaylistItemListResponse, error) {
+func (s *Service) getPlaylistVideos(
+	ctx context.Context,
+	playlistId, etag string,
+	essential bool,
+) (*youtube.PlaylistItemListResponse, error) {
 	call := s.YT.PlaylistItems.List([]string{"snippet"}).
 		PlaylistId(playlistId).
 		MaxResults(50)
+	if len(etag) > 0 {
+		call.IfNoneMatch(etag)
+	}
 
-	return call.Do()
+	var res *youtube.PlaylistItemListResponse
+	err := s.do(ctx, "playlistItems.list", essential, func() (err error) {
+		res, err = call.Context(ctx).Do()
+		return
+	})
+	if googleapi.IsNotModified(err) {
+		return nil, ErrNotModified
+	}
+	return res, err
 }
 
-// get channel uploads playlist id
-func (s *Service) getUploadsId(channelId string) (string, bool) {
-	call := s.YT.Channels.List([]string{"contentDetails"}).Id(channelId)
-	res, err := call.Do()
-	if err != nil {
-		log.Printf("get channel content details error: %s\n", err.Error())
-		return "", false
+// FetchThumbnails downloads missing thumbnails of videos and playlists,
+// keys are ThumbnailPath and values are Thumbnails of the same entity.
+// Returns count of requested thumbnails
+func (s *Service) FetchThumbnails(
+	ctx context.Context,
+	thumbnails map[string]map[string]models.Thumbnail,
+) int {
+	if s.AppConfig.ThumbOff {
+		return 0
 	}
 
-	if len(res.Items) >= 1 {
-		for _, item := range res.Items {
-			if item.ContentDetails != nil &&
-				item.ContentDetails.RelatedPlaylists != nil &&
-				len(item.ContentDetails.RelatedPlaylists.Uploads) == 24 {
-				return item.ContentDetails.RelatedPlaylists.Uploads, true
-			}
+	missing := make(map[string]string, 0)
+	for path, t := range thumbnails {
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if url := thumbnailURL(path, t); len(url) > 0 {
+			missing[s.sourcePath(path, url)] = url
 		}
 	}
+	if len(missing) > 0 {
+		s.downloadThumbnails(ctx, missing, false)
+	}
+	return len(missing)
+}
 
-	return "", false
+// failed thumbnails are only logged, missing ones are downloaded with next
+// fetch. Downloaded thumbnails are processed into icons when it's enabled.
+// Nothing is downloaded when thumbnails are disabled
+func (s *Service) downloadThumbnails(
+	ctx context.Context,
+	thumbnails map[string]string,
+	avatar bool,
+) {
+	if s.AppConfig.ThumbOff || len(thumbnails) == 0 {
+		return
+	}
+	errs := downloader.DownloadAll(ctx, thumbnails)
+	for path, err := range errs {
+		log.Printf("thumbnail %s error: %s\n", filepath.Base(path), err.Error())
+	}
+	paths := make([]string, 0, len(thumbnails))
+	for path := range thumbnails {
+		if _, failed := errs[path]; !failed {
+			paths = append(paths, path)
+		}
+	}
+	s.dedup(paths)
+	if !s.AppConfig.Icons.Enabled() {
+		return
+	}
+
+	opts := s.imageOptions(avatar)
+	errs = downloader.ProcessAll(paths, opts)
+	icons := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if err, failed := errs[path]; failed {
+			log.Printf("icon %s error: %s\n", filepath.Base(path), err.Error())
+		} else {
+			icons = append(icons, downloader.VariantPath(path, opts))
+		}
+	}
+	s.dedup(icons)
+}
+
+// links identical thumbnails to single stored copy, failures only cost
+// disk space
+func (s *Service) dedup(paths []string) {
+	store := filepath.Join(s.AppConfig.ThumbDir, consts.STORE_DIR_NAME)
+	for _, path := range paths {
+		if err := downloader.Dedup(store, path); err != nil {
+			log.Printf("dedup %s error: %s\n", filepath.Base(path), err.Error())
+		}
+	}
+}
+
+func (s *Service) imageOptions(avatar bool) downloader.ImageOptions {
+	return downloader.ImageOptions{
+		Size:   s.AppConfig.Icons.Size,
+		Crop:   s.AppConfig.Icons.Crop && !avatar,
+		Circle: s.AppConfig.Icons.CircleAvatars && avatar,
+	}
+}
+
+// path of icon shown in menus, processed variant of thumbnail when icons
+// are enabled
+func (s *Service) iconPath(path string, avatar bool) string {
+	if len(path) == 0 || !s.AppConfig.Icons.Enabled() {
+		return path
+	}
+	return downloader.VariantPath(path, s.imageOptions(avatar))
+}
+
+// path of downloaded thumbnail which is processed into icon at path
+func (s *Service) sourcePath(path, url string) string {
+	if !s.AppConfig.Icons.Enabled() {
+		return path
+	}
+	suffix := downloader.VariantPath("", s.imageOptions(false))
+	return strings.TrimSuffix(path, suffix) + thumbnailExt(url)
+}
+
+// UploadsId returns cached uploads playlist id, or derives it from channel
+// id ('UC...' channel has 'UU...' uploads playlist) when nothing is cached
+func UploadsId(channelId, cached string) string {
+	if len(cached) > 0 {
+		return cached
+	}
+	if strings.HasPrefix(channelId, "UC") {
+		return "UU" + strings.TrimPrefix(channelId, "UC")
+	}
+	return channelId
+}
+
+func uploadsFromDetails(d *youtube.ChannelContentDetails) string {
+	if d != nil && d.RelatedPlaylists != nil && len(d.RelatedPlaylists.Uploads) == 24 {
+		return d.RelatedPlaylists.Uploads
+	}
+	return ""
 }
 
 // returns Thumbnails struct by *youtube.ThumbnailDetails
@@ -221,36 +408,59 @@ func parseThumbnails(t *youtube.ThumbnailDetails) map[string]models.Thumbnail {
 	return thumbnails
 }
 
-func (s *Service) chooseThumbnail(id string, t map[string]models.Thumbnail) (string, string) {
-	switch s.AppConfig.ThumbSize {
-	case consts.SP_HIGH:
-		return s.getThumbnailsPath(id, t[consts.SP_HIGH].URL),
-			t[consts.SP_HIGH].URL
-	case consts.SP_MEDIUM:
-		return s.getThumbnailsPath(id, t[consts.SP_MEDIUM].URL),
-			t[consts.SP_MEDIUM].URL
-	default:
-		return s.getThumbnailsPath(id, t[consts.SP_DEFAULT].URL),
-			t[consts.SP_DEFAULT].URL
-	}
+// sizes tried when thumbnail of chosen size has no url, from the chosen one
+var fallbackSizes = map[string][]string{
+	consts.SP_HIGH:    {consts.SP_HIGH, consts.SP_MEDIUM, consts.SP_DEFAULT},
+	consts.SP_MEDIUM:  {consts.SP_MEDIUM, consts.SP_DEFAULT, consts.SP_HIGH},
+	consts.SP_DEFAULT: {consts.SP_DEFAULT, consts.SP_MEDIUM, consts.SP_HIGH},
 }
 
-// Join path for thumbnails from cache path, id and extension, taken from url
-func (s *Service) getThumbnailsPath(id, url string) string {
-	ext := filepath.Ext(filepath.Base(url))
-	if len(ext) == 0 && len(filepath.Base(url)) != 0 {
-		ext = ".jpg"
+// returns path and url of thumbnail of given size, or of the next available
+// size when it has no url. Both are empty when there are no urls at all
+func (s *Service) chooseThumbnail(
+	id, size string,
+	t map[string]models.Thumbnail,
+) (string, string) {
+	if len(size) == 0 {
+		size = s.AppConfig.ThumbSize
 	}
-	sizePrefix := consts.SP_DEFAULT
-	switch s.AppConfig.ThumbSize {
-	case consts.SP_HIGH:
-		sizePrefix = consts.SP_HIGH
-	case consts.SP_MEDIUM:
-		sizePrefix = consts.SP_MEDIUM
-
+	sizes, ok := fallbackSizes[size]
+	if !ok {
+		sizes = fallbackSizes[consts.SP_DEFAULT]
 	}
+	for _, size := range sizes {
+		if url := t[size].URL; len(url) > 0 {
+			return s.getThumbnailsPath(id, size, url), url
+		}
+	}
+	return "", ""
+}
+
+// Join path for thumbnails from cache path, size, id and extension, taken
+// from url
+func (s *Service) getThumbnailsPath(id, size, url string) string {
 	return filepath.Join(
 		s.AppConfig.ThumbDir,
-		sizePrefix+id+ext,
+		size+id+thumbnailExt(url),
 	)
 }
+
+// url of thumbnail at path, size is taken from path prefix
+func thumbnailURL(path string, t map[string]models.Thumbnail) string {
+	base := filepath.Base(path)
+	for size, thumbnail := range t {
+		if strings.HasPrefix(base, size) {
+			return thumbnail.URL
+		}
+	}
+	return ""
+}
+
+// extension taken from url, '.jpg' when url has none
+func thumbnailExt(url string) string {
+	ext := filepath.Ext(filepath.Base(url))
+	if len(ext) == 0 && len(filepath.Base(url)) != 0 {
+		ext = ".jpg"
+	}
+	return ext
+}
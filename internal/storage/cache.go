@@ -0,0 +1,402 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/su55y/yt_feed/internal/cachefile"
+	"github.com/su55y/yt_feed/internal/consts"
+	"github.com/su55y/yt_feed/internal/models"
+	"github.com/su55y/yt_feed/pkg/downloader"
+)
+
+// files younger than grace period are never collected, they might belong
+// to update running in another instance
+const gcGracePeriod = time.Hour
+
+// cache files kinds
+const (
+	KindChannels   = "channels"
+	KindUploads    = "uploads"
+	KindPlaylists  = "playlists"
+	KindThumbnails = "thumbnails"
+	KindOther      = "other"
+)
+
+// CacheStats counts files and their size by kind, orphaned files are
+// counted in their kind and once more in Orphaned
+type CacheStats struct {
+	Files         map[string]int
+	Bytes         map[string]int64
+	Orphaned      int
+	OrphanedBytes int64
+}
+
+func (st CacheStats) Total() (int, int64) {
+	files, size := 0, int64(0)
+	for k, n := range st.Files {
+		files += n
+		size += st.Bytes[k]
+	}
+	return files, size
+}
+
+type cacheFile struct {
+	path string
+	kind string
+	info fs.FileInfo
+}
+
+// files and thumbnails referenced by cache of configured channels, sources
+// are thumbnails paths without extension which processed icons are made of
+type references struct {
+	files   map[string]bool
+	thumbs  map[string]bool
+	sources map[string]bool
+}
+
+// CacheStats returns usage of cache directory, downloaded videos are not
+// counted
+func (s *Storage) CacheStats() (CacheStats, error) {
+	st := CacheStats{
+		Files: make(map[string]int, 0),
+		Bytes: make(map[string]int64, 0),
+	}
+	files, err := s.cacheFiles()
+	if err != nil {
+		return st, err
+	}
+	refs := s.references()
+	for _, f := range files {
+		st.Files[f.kind]++
+		st.Bytes[f.kind] += f.info.Size()
+		if s.orphaned(f, refs) {
+			st.Orphaned++
+			st.OrphanedBytes += f.info.Size()
+		}
+	}
+	return st, nil
+}
+
+// CacheGC removes data files and thumbnails which are not referenced by
+// cache of configured channels, returns count and size of removed files
+func (s *Storage) CacheGC() (int, int64, error) {
+	files, err := s.cacheFiles()
+	if err != nil {
+		return 0, 0, err
+	}
+	refs := s.references()
+	removed, freed := 0, int64(0)
+	for _, f := range files {
+		if !s.orphaned(f, refs) {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			log.Printf("remove %#v error: %s\n", f.path, err.Error())
+			continue
+		}
+		removed++
+		freed += f.info.Size()
+	}
+	s.pruneMeta(refs)
+
+	n, size, err := downloader.PruneStore(s.storeDir())
+	if err != nil {
+		log.Printf("prune thumbnails store error: %s\n", err.Error())
+	}
+	return removed + n, freed + size, nil
+}
+
+// CacheVerify decodes every cache file and checks that channel avatars
+// exist, returns found problems. Corrupt files are moved aside
+func (s *Storage) CacheVerify() ([]string, error) {
+	files, err := s.cacheFiles()
+	if err != nil {
+		return nil, err
+	}
+	problems := make([]string, 0)
+	for _, f := range files {
+		if f.kind == KindThumbnails || filepath.Ext(f.path) != consts.EXT_JSON {
+			continue
+		}
+		var v interface{}
+		if err := cachefile.Read(f.path, &v); cachefile.Unusable(err) {
+			problems = append(problems, err.Error())
+		} else if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", filepath.Base(f.path), err.Error()))
+		}
+	}
+
+	if s.AppConfig.ThumbOff {
+		return problems, nil
+	}
+	// videos and playlists thumbnails are fetched when they are shown, only
+	// channel avatars are downloaded with channels
+	channels, err := s.CachedChannels()
+	if err != nil {
+		return problems, nil
+	}
+	missing := 0
+	for _, id := range s.AppConfig.Channels {
+		if path := channels[id].ThumbnailPath; len(path) > 0 && !exists(path) {
+			missing++
+		}
+	}
+	if missing > 0 {
+		problems = append(problems, fmt.Sprintf("%d channel avatars are missing", missing))
+	}
+	return problems, nil
+}
+
+// CacheClear removes cached uploads and playlists of the channel, whole
+// cache with channels and thumbnails is removed when channelId is empty.
+// Playback history, queue and downloads are kept
+func (s *Storage) CacheClear(channelId string) error {
+	if len(channelId) == 0 {
+		files, err := s.cacheFiles()
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if f.kind == KindOther {
+				continue
+			}
+			if err := os.Remove(f.path); err != nil {
+				return err
+			}
+		}
+		if err := os.RemoveAll(s.storeDir()); err != nil {
+			return err
+		}
+		return removeIfExists(filepath.Join(s.AppConfig.CachePath, metaFile))
+	}
+
+	keys := []string{consts.P_VIDEOS + channelId, consts.P_PLAYLISTS + channelId}
+	for _, p := range s.cachedPlaylists(channelId) {
+		keys = append(keys, consts.P_VIDEOS+p.Id, playlistKey(channelId, p.Id))
+	}
+	for _, key := range keys {
+		if err := removeIfExists(filepath.Join(s.AppConfig.CachePath, key+consts.EXT_JSON)); err != nil {
+			return err
+		}
+	}
+	s.deleteCacheMeta(keys...)
+	return nil
+}
+
+// enforceSizeCap collects garbage and removes oldest thumbnails until
+// cache fits into cache_max_mb
+func (s *Storage) enforceSizeCap() {
+	limit := s.AppConfig.CacheMaxMB * 1024 * 1024
+	if limit <= 0 {
+		return
+	}
+	if _, _, err := s.CacheGC(); err != nil {
+		log.Printf("cache gc error: %s\n", err.Error())
+		return
+	}
+
+	files, err := s.cacheFiles()
+	if err != nil {
+		log.Printf("read cache files error: %s\n", err.Error())
+		return
+	}
+	size := int64(0)
+	thumbs := make([]cacheFile, 0)
+	for _, f := range files {
+		size += f.info.Size()
+		if f.kind == KindThumbnails {
+			thumbs = append(thumbs, f)
+		}
+	}
+	sort.Slice(thumbs, func(i, j int) bool {
+		return thumbs[i].info.ModTime().Before(thumbs[j].info.ModTime())
+	})
+	for _, f := range thumbs {
+		if size <= limit {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			log.Printf("remove %#v error: %s\n", f.path, err.Error())
+			continue
+		}
+		size -= f.info.Size()
+	}
+	// removed thumbnails might be the last links to stored objects
+	if _, _, err := downloader.PruneStore(s.storeDir()); err != nil {
+		log.Printf("prune thumbnails store error: %s\n", err.Error())
+	}
+	if size > limit {
+		log.Printf("cache size %d exceeds cache_max_mb\n", size)
+	}
+}
+
+// lists cache files and thumbnails, downloads and directories are skipped
+func (s *Storage) cacheFiles() ([]cacheFile, error) {
+	files := make([]cacheFile, 0)
+	entries, err := os.ReadDir(s.AppConfig.CachePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{
+			path: filepath.Join(s.AppConfig.CachePath, e.Name()),
+			kind: fileKind(e.Name()),
+			info: info,
+		})
+	}
+
+	thumbs, err := os.ReadDir(s.AppConfig.ThumbDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range thumbs {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{
+			path: filepath.Join(s.AppConfig.ThumbDir, e.Name()),
+			kind: KindThumbnails,
+			info: info,
+		})
+	}
+	return files, nil
+}
+
+func fileKind(name string) string {
+	switch {
+	case name == channelsFile:
+		return KindChannels
+	case !strings.HasSuffix(name, consts.EXT_JSON):
+		return KindOther
+	case strings.HasPrefix(name, consts.P_VIDEOS):
+		return KindUploads
+	case strings.HasPrefix(name, consts.P_PLAYLISTS):
+		return KindPlaylists
+	}
+	return KindOther
+}
+
+func (s *Storage) orphaned(f cacheFile, refs references) bool {
+	name := filepath.Base(f.path)
+	switch f.kind {
+	case KindUploads, KindPlaylists:
+		return !refs.files[name]
+	case KindThumbnails:
+		source := strings.TrimSuffix(f.path, filepath.Ext(f.path))
+		return !refs.thumbs[f.path] && !refs.sources[source] &&
+			time.Since(f.info.ModTime()) > gcGracePeriod
+	case KindOther:
+		// leftovers of interrupted writes and moved aside corrupt files
+		return (strings.Contains(name, ".tmp") || strings.HasSuffix(name, ".corrupt")) &&
+			time.Since(f.info.ModTime()) > gcGracePeriod
+	}
+	return false
+}
+
+func (s *Storage) references() references {
+	refs := references{
+		files:   make(map[string]bool, 0),
+		thumbs:  make(map[string]bool, 0),
+		sources: make(map[string]bool, 0),
+	}
+	addVideos := func(videos []models.Video) {
+		for _, v := range videos {
+			refs.thumbs[v.ThumbnailPath] = true
+		}
+	}
+
+	channels, err := s.CachedChannels()
+	if err != nil {
+		channels = make(map[string]models.Channel, 0)
+	}
+	for _, id := range s.AppConfig.Channels {
+		refs.thumbs[channels[id].ThumbnailPath] = true
+
+		uploads := consts.P_VIDEOS + id + consts.EXT_JSON
+		refs.files[uploads] = true
+		if videos, err := s.readVideosFromFile(filepath.Join(s.AppConfig.CachePath, uploads)); err == nil {
+			addVideos(videos)
+		}
+
+		refs.files[consts.P_PLAYLISTS+id+consts.EXT_JSON] = true
+		for _, p := range s.cachedPlaylists(id) {
+			refs.thumbs[p.ThumbnailPath] = true
+			addVideos(p.Videos)
+			playlist := consts.P_VIDEOS + p.Id + consts.EXT_JSON
+			refs.files[playlist] = true
+			if videos, err := s.readVideosFromFile(filepath.Join(s.AppConfig.CachePath, playlist)); err == nil {
+				addVideos(videos)
+			}
+		}
+	}
+
+	if queue, err := s.ReadQueue(); err == nil {
+		addVideos(queue)
+	}
+	delete(refs.thumbs, "")
+	for path := range refs.thumbs {
+		if i := strings.LastIndex(path, "_"); i > 0 && filepath.Ext(path) == ".png" {
+			refs.sources[path[:i]] = true
+		}
+	}
+	return refs
+}
+
+// returns cached playlists of the channel without fetching them
+func (s *Storage) cachedPlaylists(channelId string) []models.Playlist {
+	playlists := make([]models.Playlist, 0)
+	path := filepath.Join(
+		s.AppConfig.CachePath,
+		consts.P_PLAYLISTS+channelId+consts.EXT_JSON,
+	)
+	if exists(path) {
+		if err := cachefile.Read(path, &playlists); err != nil {
+			log.Printf("read playlists from file error: %s\n", err.Error())
+		}
+	}
+	return playlists
+}
+
+// drop meta of data files which are not referenced anymore, meta of
+// playlist videos belongs to playlists file, see playlistKey
+func (s *Storage) pruneMeta(refs references) {
+	keys := make([]string, 0)
+	for key := range s.readMetaLocked() {
+		file, _, _ := strings.Cut(key, ".")
+		if key != channelsKey && !refs.files[file+consts.EXT_JSON] {
+			keys = append(keys, key)
+		}
+	}
+	s.deleteCacheMeta(keys...)
+}
+
+// content-addressed store of deduplicated thumbnails, see downloader.Dedup
+func (s *Storage) storeDir() string {
+	return filepath.Join(s.AppConfig.ThumbDir, consts.STORE_DIR_NAME)
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
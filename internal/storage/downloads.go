@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+
+	"github.com/su55y/yt_feed/internal/cachefile"
+	"github.com/su55y/yt_feed/internal/models"
+)
+
+const (
+	downloadsFile = "downloads.json"
+)
+
+var downloadsMu sync.Mutex
+
+// read finished downloads, missing file means no downloads
+func (s *Storage) ReadDownloads() ([]models.Download, error) {
+	downloadsMu.Lock()
+	defer downloadsMu.Unlock()
+	return s.readDownloads()
+}
+
+// add download record or replace existing one with the same video and preset
+func (s *Storage) SaveDownload(d models.Download) error {
+	downloadsMu.Lock()
+	defer downloadsMu.Unlock()
+
+	return s.updateDownloads(func(downloads []models.Download) []models.Download {
+		for i, old := range downloads {
+			if old.VideoId == d.VideoId && old.Preset == d.Preset {
+				downloads[i] = d
+				return downloads
+			}
+		}
+		return append(downloads, d)
+	})
+}
+
+// remove download records, files are not touched
+func (s *Storage) RemoveDownloads(remove ...models.Download) error {
+	downloadsMu.Lock()
+	defer downloadsMu.Unlock()
+
+	return s.updateDownloads(func(downloads []models.Download) []models.Download {
+		kept := make([]models.Download, 0, len(downloads))
+		for _, d := range downloads {
+			removed := false
+			for _, r := range remove {
+				if d.VideoId == r.VideoId && d.Preset == r.Preset {
+					removed = true
+					break
+				}
+			}
+			if !removed {
+				kept = append(kept, d)
+			}
+		}
+		return kept
+	})
+}
+
+func (s *Storage) readDownloads() ([]models.Download, error) {
+	downloads := make([]models.Download, 0)
+	path := filepath.Join(s.AppConfig.CachePath, downloadsFile)
+	if !exists(path) {
+		return downloads, nil
+	}
+
+	if err := cachefile.Read(path, &downloads); err != nil {
+		log.Printf("read downloads from file error: %s\n", err.Error())
+		return nil, err
+	}
+	return downloads, nil
+}
+
+func (s *Storage) updateDownloads(f func([]models.Download) []models.Download) error {
+	downloads := make([]models.Download, 0)
+	path := filepath.Join(s.AppConfig.CachePath, downloadsFile)
+	if err := cachefile.Update(path, &downloads, func() error {
+		downloads = f(downloads)
+		return nil
+	}); err != nil {
+		log.Printf("write to %#v file error: %s\n", path, err.Error())
+		return err
+	}
+	return nil
+}
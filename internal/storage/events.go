@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"github.com/su55y/yt_feed/internal/models"
+)
+
+// Event describes changes found while updating cache
+type Event struct {
+	Type      string
+	ChannelId string
+	Videos    []models.Video
+	// update error for failed updates
+	Err error
+	// channel titles for renamed channels
+	OldTitle string
+	NewTitle string
+}
+
+type Listener func(Event)
+
+// Subscribe registers listener for update events, should be called before
+// any update is started
+func (s *Storage) Subscribe(l Listener) {
+	s.listeners = append(s.listeners, l)
+}
+
+func (s *Storage) emit(e Event) {
+	for _, l := range s.listeners {
+		l(e)
+	}
+}
+
+// returns videos which are not present in old list
+func newVideos(old, videos []models.Video) []models.Video {
+	seen := make(map[string]bool, len(old))
+	for _, v := range old {
+		seen[v.Id] = true
+	}
+
+	added := make([]models.Video, 0)
+	for _, v := range videos {
+		if !seen[v.Id] {
+			added = append(added, v)
+		}
+	}
+	return added
+}
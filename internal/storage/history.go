@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/su55y/yt_feed/internal/cachefile"
+	"github.com/su55y/yt_feed/internal/models"
+)
+
+const (
+	historyFile = "history.json"
+
+	defaultWatchedPercent = 90
+)
+
+var historyMu sync.Mutex
+
+// read playback history by video id, missing file means empty history
+func (s *Storage) ReadHistory() (map[string]models.HistoryEntry, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	return s.readHistory()
+}
+
+// store playback position of the video, marks video watched when
+// position reaches watched_percent of its duration
+func (s *Storage) SavePosition(videoId string, position, duration float64) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	percent := s.AppConfig.WatchedPercent
+	if percent <= 0 || percent > 100 {
+		percent = defaultWatchedPercent
+	}
+
+	history := make(map[string]models.HistoryEntry, 0)
+	path := filepath.Join(s.AppConfig.CachePath, historyFile)
+	err := cachefile.Update(path, &history, func() error {
+		if history == nil {
+			history = make(map[string]models.HistoryEntry, 0)
+		}
+		entry := history[videoId]
+		entry.Position = position
+		entry.Duration = duration
+		entry.Updated = time.Now()
+		if duration > 0 && position*100/duration >= float64(percent) {
+			entry.Watched = true
+		}
+		history[videoId] = entry
+		return nil
+	})
+	if err != nil {
+		log.Printf("write to %#v file error: %s\n", path, err.Error())
+	}
+	return err
+}
+
+func (s *Storage) readHistory() (map[string]models.HistoryEntry, error) {
+	history := make(map[string]models.HistoryEntry, 0)
+	path := filepath.Join(s.AppConfig.CachePath, historyFile)
+	if !exists(path) {
+		return history, nil
+	}
+
+	if err := cachefile.Read(path, &history); err != nil {
+		log.Printf("read history from file error: %s\n", err.Error())
+		return nil, err
+	}
+	return history, nil
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/su55y/yt_feed/internal/cachefile"
+	"github.com/su55y/yt_feed/internal/consts"
+	"github.com/su55y/yt_feed/internal/models"
+)
+
+const (
+	metaFile = "meta.json"
+
+	defaultChannelsTTL  = 7 * 24 * time.Hour
+	defaultUploadsTTL   = time.Hour
+	defaultPlaylistsTTL = 24 * time.Hour
+)
+
+var metaMu sync.Mutex
+
+// returns meta of cache file by its name without extension
+func (s *Storage) cacheMeta(key string) models.CacheMeta {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	return s.readMeta()[key]
+}
+
+// store etag of fresh response, fetch time is set to now
+func (s *Storage) setCacheMeta(key, etag string) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+
+	meta := make(map[string]models.CacheMeta, 0)
+	path := filepath.Join(s.AppConfig.CachePath, metaFile)
+	if err := cachefile.Update(path, &meta, func() error {
+		if meta == nil {
+			meta = make(map[string]models.CacheMeta, 0)
+		}
+		meta[key] = models.CacheMeta{ETag: etag, Fetched: time.Now()}
+		return nil
+	}); err != nil {
+		log.Printf("write to %#v file error: %s\n", path, err.Error())
+	}
+}
+
+// meta key of channel playlist videos, it's kept while playlists file of
+// channel exists
+func playlistKey(channelId, playlistId string) string {
+	return consts.P_PLAYLISTS + channelId + "." + playlistId
+}
+
+// returns etags of channel playlists videos by playlist id
+func (s *Storage) playlistEtags(channelId string) map[string]string {
+	prefix := playlistKey(channelId, "")
+	etags := make(map[string]string, 0)
+	for key, m := range s.readMetaLocked() {
+		if strings.HasPrefix(key, prefix) {
+			etags[strings.TrimPrefix(key, prefix)] = m.ETag
+		}
+	}
+	return etags
+}
+
+// replaces etags of channel playlists videos, etags of removed playlists
+// are dropped
+func (s *Storage) setPlaylistEtags(channelId string, etags map[string]string) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+
+	prefix := playlistKey(channelId, "")
+	meta := make(map[string]models.CacheMeta, 0)
+	path := filepath.Join(s.AppConfig.CachePath, metaFile)
+	if err := cachefile.Update(path, &meta, func() error {
+		if meta == nil {
+			meta = make(map[string]models.CacheMeta, 0)
+		}
+		for key := range meta {
+			if strings.HasPrefix(key, prefix) {
+				delete(meta, key)
+			}
+		}
+		for id, etag := range etags {
+			meta[prefix+id] = models.CacheMeta{ETag: etag, Fetched: time.Now()}
+		}
+		return nil
+	}); err != nil {
+		log.Printf("write to %#v file error: %s\n", path, err.Error())
+	}
+}
+
+// remove meta of deleted cache files
+func (s *Storage) deleteCacheMeta(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	metaMu.Lock()
+	defer metaMu.Unlock()
+
+	meta := make(map[string]models.CacheMeta, 0)
+	path := filepath.Join(s.AppConfig.CachePath, metaFile)
+	if err := cachefile.Update(path, &meta, func() error {
+		for _, key := range keys {
+			delete(meta, key)
+		}
+		return nil
+	}); err != nil {
+		log.Printf("write to %#v file error: %s\n", path, err.Error())
+	}
+}
+
+func (s *Storage) readMetaLocked() map[string]models.CacheMeta {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	return s.readMeta()
+}
+
+// reports whether cache was never fetched or is older than ttl
+func (s *Storage) stale(key string, ttl time.Duration) bool {
+	fetched := s.cacheMeta(key).Fetched
+	return fetched.IsZero() || time.Since(fetched) > ttl
+}
+
+// returns fetch time of channel uploads, zero time if it was never fetched
+func (s *Storage) UploadsFetched(channelId string) time.Time {
+	return s.cacheMeta(consts.P_VIDEOS + channelId).Fetched
+}
+
+// returns fetch time of channel playlists, zero time if it was never fetched
+func (s *Storage) PlaylistsFetched(channelId string) time.Time {
+	return s.cacheMeta(consts.P_PLAYLISTS + channelId).Fetched
+}
+
+func (s *Storage) channelsTTL() time.Duration {
+	if s.AppConfig.TTL.Channels > 0 {
+		return s.AppConfig.TTL.Channels
+	}
+	return defaultChannelsTTL
+}
+
+func (s *Storage) uploadsTTL() time.Duration {
+	if s.AppConfig.TTL.Uploads > 0 {
+		return s.AppConfig.TTL.Uploads
+	}
+	return defaultUploadsTTL
+}
+
+func (s *Storage) playlistsTTL() time.Duration {
+	if s.AppConfig.TTL.Playlists > 0 {
+		return s.AppConfig.TTL.Playlists
+	}
+	return defaultPlaylistsTTL
+}
+
+func (s *Storage) readMeta() map[string]models.CacheMeta {
+	meta := make(map[string]models.CacheMeta, 0)
+	path := filepath.Join(s.AppConfig.CachePath, metaFile)
+	if !exists(path) {
+		return meta
+	}
+
+	if err := cachefile.Read(path, &meta); err != nil {
+		log.Printf("read meta from file error: %s\n", err.Error())
+		return make(map[string]models.CacheMeta, 0)
+	}
+	return meta
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/su55y/yt_feed/internal/cachefile"
+	"github.com/su55y/yt_feed/internal/consts"
+	"github.com/su55y/yt_feed/internal/models"
+	"github.com/su55y/yt_feed/pkg/downloader"
+)
+
+// suffix of processed icon, see downloader.VariantPath
+var variantSuffix = regexp.MustCompile(`_\d+c?o?\.png$`)
+
+func init() {
+	cachefile.Register(2, migrateThumbnailKeys)
+}
+
+// video thumbnails were keyed by playlist item id, so the same video in
+// uploads and playlists was downloaded twice. Paths are rewritten to video
+// ids and existing files are renamed, duplicates are removed
+func migrateThumbnailKeys(name string, data json.RawMessage) (json.RawMessage, error) {
+	switch {
+	case name == queueFile, strings.HasPrefix(name, consts.P_VIDEOS):
+		var videos []models.Video
+		if err := json.Unmarshal(data, &videos); err != nil {
+			return nil, err
+		}
+		rekeyVideos(videos)
+		return json.Marshal(videos)
+	case strings.HasPrefix(name, consts.P_PLAYLISTS):
+		var playlists []models.Playlist
+		if err := json.Unmarshal(data, &playlists); err != nil {
+			return nil, err
+		}
+		for _, p := range playlists {
+			rekeyVideos(p.Videos)
+		}
+		return json.Marshal(playlists)
+	}
+	return data, nil
+}
+
+func rekeyVideos(videos []models.Video) {
+	for i, v := range videos {
+		videos[i].ThumbnailPath = rekeyThumbnail(v.ThumbnailPath, v.Id)
+	}
+}
+
+// returns thumbnail path keyed by video id, thumbnail and its icons are
+// moved to the new path when it's missing
+func rekeyThumbnail(path, videoId string) string {
+	if len(path) == 0 || len(videoId) == 0 {
+		return path
+	}
+	dir, base := filepath.Split(path)
+	size := ""
+	for _, sp := range []string{consts.SP_HIGH, consts.SP_MEDIUM, consts.SP_DEFAULT} {
+		if strings.HasPrefix(base, sp) {
+			size = sp
+			break
+		}
+	}
+	suffix := variantSuffix.FindString(base)
+	if len(suffix) == 0 {
+		suffix = filepath.Ext(base)
+	}
+	if len(size) == 0 || len(base) <= len(size)+len(suffix) {
+		return path
+	}
+	oldPrefix := size + base[len(size):len(base)-len(suffix)]
+	newPrefix := size + videoId
+	if oldPrefix == newPrefix {
+		return path
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		// thumbnail with all of its processed icons
+		matches, _ := filepath.Glob(filepath.Join(dir, oldPrefix) + "*")
+		for _, old := range matches {
+			consolidate(old, filepath.Join(dir, newPrefix+strings.TrimPrefix(filepath.Base(old), oldPrefix)))
+		}
+	}
+	return filepath.Join(dir, newPrefix+suffix)
+}
+
+// moves duplicate to path, or removes it when path already exists. Kept
+// file is linked to thumbnails store
+func consolidate(duplicate, path string) {
+	if _, err := os.Stat(path); err == nil {
+		if err := os.Remove(duplicate); err != nil {
+			log.Printf("remove duplicate %s error: %s\n", filepath.Base(duplicate), err.Error())
+		}
+		return
+	}
+	if err := os.Rename(duplicate, path); err != nil {
+		log.Printf("rename %s error: %s\n", filepath.Base(duplicate), err.Error())
+		return
+	}
+	store := filepath.Join(filepath.Dir(path), consts.STORE_DIR_NAME)
+	if err := downloader.Dedup(store, path); err != nil {
+		log.Printf("dedup %s error: %s\n", filepath.Base(path), err.Error())
+	}
+}
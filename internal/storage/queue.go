@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/su55y/yt_feed/internal/cachefile"
+	"github.com/su55y/yt_feed/internal/models"
+)
+
+const (
+	queueFile = "queue.json"
+)
+
+// read persistent play queue, missing file means empty queue
+func (s *Storage) ReadQueue() ([]models.Video, error) {
+	videos := make([]models.Video, 0)
+	path := filepath.Join(s.AppConfig.CachePath, queueFile)
+	if !exists(path) {
+		return videos, nil
+	}
+
+	if err := cachefile.Read(path, &videos); err != nil {
+		log.Printf("read queue from file error: %s\n", err.Error())
+		return nil, err
+	}
+	return videos, nil
+}
+
+// append videos to the queue, returns new queue length
+func (s *Storage) Enqueue(videos ...models.Video) (int, error) {
+	queue := make([]models.Video, 0)
+	path := filepath.Join(s.AppConfig.CachePath, queueFile)
+	if err := cachefile.Update(path, &queue, func() error {
+		queue = append(queue, videos...)
+		return nil
+	}); err != nil {
+		log.Printf("write to %#v file error: %s\n", path, err.Error())
+		return 0, err
+	}
+	return len(queue), nil
+}
+
+func (s *Storage) ClearQueue() error {
+	path := filepath.Join(s.AppConfig.CachePath, queueFile)
+	if err := cachefile.Write(path, []models.Video{}); err != nil {
+		log.Printf("write to %#v file error: %s\n", path, err.Error())
+		return err
+	}
+	return nil
+}
@@ -1,15 +1,16 @@
 package storage
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
+	"github.com/su55y/yt_feed/internal/cachefile"
 	"github.com/su55y/yt_feed/internal/config"
 	"github.com/su55y/yt_feed/internal/consts"
 	"github.com/su55y/yt_feed/internal/models"
@@ -18,11 +19,22 @@ import (
 
 const (
 	channelsFile = "channels.json"
+	channelsKey  = "channels"
+
+	defaultConcurrency    = 8
+	defaultChannelTimeout = 60 * time.Second
 )
 
+var channelsMu sync.Mutex
+
+// cancels channels update when title didn't change
+var errNotRenamed = errors.New("channel is not renamed")
+
 type Storage struct {
 	AppConfig *config.AppConfig
 	Service   *service.Service
+
+	listeners []Listener
 }
 
 func New(conf *config.AppConfig, serv *service.Service) Storage {
@@ -32,55 +44,261 @@ func New(conf *config.AppConfig, serv *service.Service) Storage {
 	}
 }
 
-func (s *Storage) UpdateAll(channels map[string]models.Channel) bool {
+// UpdateAll updates stale uploads and playlists of all channels, force
+// updates them regardless of ttl. Returns newly seen uploads by channel id
+// and first uploads update error. Every finished channel emits
+// EV_CHANNEL_UPDATED event. Count of channels updated at once is limited
+// by concurrency option, every channel update is canceled after
+// channel_timeout
+func (s *Storage) UpdateAll(
+	ctx context.Context,
+	channels map[string]models.Channel,
+	force bool,
+) (map[string][]models.Video, error) {
 	var wg sync.WaitGroup
-	wg.Add(len(channels))
+	var mu sync.Mutex
+	var firstErr error
+	added := make(map[string][]models.Video, 0)
 	updateChannel := func(channelId string) {
-		defer wg.Done()
-		if _, err := s.ReadUploads(channelId, true); err != nil {
-			log.Printf("error while updating channel %s uploads: %s", channelId, err.Error())
+		ctx, cancel := context.WithTimeout(ctx, s.channelTimeout())
+		defer cancel()
+		var newVideos []models.Video
+		var uploadsErr error
+		if force || s.stale(consts.P_VIDEOS+channelId, s.uploadsTTL()) {
+			_, newVideos, uploadsErr = s.updateUploads(ctx, channelId)
+		}
+		if uploadsErr != nil {
+			log.Printf("error while updating channel %s uploads: %s", channelId, uploadsErr.Error())
+			s.emit(Event{Type: consts.EV_UPDATE_FAILED, ChannelId: channelId, Err: uploadsErr})
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = uploadsErr
+			}
+			mu.Unlock()
+		} else if len(newVideos) > 0 {
+			mu.Lock()
+			added[channelId] = newVideos
+			mu.Unlock()
+		}
+		var err error
+		if force || s.stale(consts.P_PLAYLISTS+channelId, s.playlistsTTL()) {
+			_, err = s.ReadAllPlaylists(ctx, channelId, true)
 		}
-		if _, err := s.ReadAllPlaylists(channelId, true); err != nil {
+		switch {
+		case errors.Is(err, service.ErrQuotaReserve):
+			log.Printf("channel %s playlists update deferred: %s", channelId, err.Error())
+			err = nil
+		case err != nil:
 			log.Printf("error while updating channel %s playlists: %s", channelId, err.Error())
+			s.emit(Event{Type: consts.EV_UPDATE_FAILED, ChannelId: channelId, Err: err})
 		}
+
+		if uploadsErr != nil {
+			err = uploadsErr
+		}
+		s.emit(Event{Type: consts.EV_CHANNEL_UPDATED, ChannelId: channelId, Err: err})
+	}
+
+	jobs := make(chan string)
+	workers := s.concurrency()
+	if workers > len(channels) {
+		workers = len(channels)
+	}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for channelId := range jobs {
+				updateChannel(channelId)
+			}
+		}()
 	}
 
 	for _, c := range channels {
-		go updateChannel(c.Id)
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- c.Id
 	}
+	close(jobs)
 	wg.Wait()
-	return true
+	s.Service.Quota.Flush()
+	s.enforceSizeCap()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return added, firstErr
+}
+
+func (s *Storage) concurrency() int {
+	if s.AppConfig.Concurrency > 0 {
+		return s.AppConfig.Concurrency
+	}
+	return defaultConcurrency
+}
+
+func (s *Storage) channelTimeout() time.Duration {
+	if s.AppConfig.ChannelTimeout > 0 {
+		return s.AppConfig.ChannelTimeout
+	}
+	return defaultChannelTimeout
 }
 
-func (s *Storage) ReadChannels() (map[string]models.Channel, error) {
+// ReadChannels reconciles cached channels with configured ids: new ids are
+// fetched and removed ones are dropped. All channels are fetched again when
+// cache is older than channels ttl, cached channels are kept when it fails
+func (s *Storage) ReadChannels(ctx context.Context) (map[string]models.Channel, error) {
+	// renames found by refresh are emitted after lock is released
+	renamed := make([]Event, 0)
+	defer func() {
+		for _, e := range renamed {
+			s.emit(e)
+		}
+	}()
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+
 	path := filepath.Join(s.AppConfig.CachePath, channelsFile)
-	if !exists(path) {
-		channels, err := s.Service.GetChannels()
-		if err != nil {
+	cached := make(map[string]models.Channel, 0)
+	if exists(path) {
+		var err error
+		cached, err = s.readChannelsFromFile()
+		if err != nil && !cachefile.Unusable(err) {
 			return nil, err
 		}
-		if !s.writeChannelsToFile(channels) {
-			return nil, errors.New("can't write channels to file")
+	}
+
+	channels := make(map[string]models.Channel, 0)
+	missing := make([]string, 0)
+	for _, id := range s.AppConfig.Channels {
+		if c, ok := cached[id]; ok {
+			channels[id] = c
+		} else {
+			missing = append(missing, id)
 		}
+	}
+	changed := len(channels) != len(cached)
 
-		return channels, nil
+	refresh := s.stale(channelsKey, s.channelsTTL())
+	fetch := missing
+	if refresh {
+		fetch = s.AppConfig.Channels
 	}
+	if len(fetch) > 0 {
+		fetched, err := s.Service.GetChannels(ctx, fetch, channels)
+		if err != nil {
+			if len(channels) == 0 {
+				return nil, err
+			}
+			log.Printf("channels refresh error, using cached channels: %s\n", err.Error())
+			refresh = false
+		}
+		for id, c := range fetched {
+			if old, ok := channels[id]; ok && old.Title != c.Title {
+				renamed = append(renamed, Event{
+					Type:      consts.EV_CHANNEL_RENAMED,
+					ChannelId: id,
+					OldTitle:  old.Title,
+					NewTitle:  c.Title,
+				})
+			}
+			channels[id] = c
+			changed = true
+		}
+		for _, id := range missing {
+			if _, ok := fetched[id]; !ok && err == nil {
+				log.Printf("channel %s not found\n", id)
+			}
+		}
+	}
+
+	if changed && !s.writeChannelsToFile(channels) {
+		renamed = nil
+		return nil, errors.New("can't write channels to file")
+	}
+	if refresh {
+		s.setCacheMeta(channelsKey, "")
+	}
+	return channels, nil
+}
+
+// CachedChannels returns cached channels without refreshing them
+func (s *Storage) CachedChannels() (map[string]models.Channel, error) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	return s.readChannelsFromFile()
+}
 
+func (s *Storage) readChannelsFromFile() (map[string]models.Channel, error) {
+	path := filepath.Join(s.AppConfig.CachePath, channelsFile)
 	channels := make(map[string]models.Channel, 0)
-	channelsRaw, err := ioutil.ReadFile(path)
-	if err != nil {
+	if err := cachefile.Read(path, &channels); err != nil {
 		log.Printf("read channels from file error: %s\n", err.Error())
 		return nil, err
 	}
+	return channels, nil
+}
 
-	if err := json.Unmarshal([]byte(channelsRaw), &channels); err != nil {
-		log.Printf("channels unmarshal error: %s\n", err.Error())
-		return nil, err
+// returns uploads playlist id of cached channel
+func (s *Storage) uploadsId(channelId string) string {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+
+	cached := ""
+	path := filepath.Join(s.AppConfig.CachePath, channelsFile)
+	if exists(path) {
+		if channels, err := s.readChannelsFromFile(); err == nil {
+			cached = channels[channelId].UploadsPlaylistId
+		}
+	}
+	return service.UploadsId(channelId, cached)
+}
+
+// uploads carry current channel title, cached channel is renamed when it differs
+func (s *Storage) checkRename(channelId string, videos []models.Video) {
+	title := ""
+	for _, v := range videos {
+		if v.ChannelId == channelId && len(v.ChannelTitle) > 0 {
+			title = v.ChannelTitle
+			break
+		}
+	}
+	if len(title) == 0 {
+		return
+	}
+
+	channelsMu.Lock()
+	oldTitle := ""
+	channels := make(map[string]models.Channel, 0)
+	path := filepath.Join(s.AppConfig.CachePath, channelsFile)
+	err := cachefile.Update(path, &channels, func() error {
+		c, ok := channels[channelId]
+		if !ok || c.Title == title {
+			return errNotRenamed
+		}
+		oldTitle = c.Title
+		c.Title = title
+		channels[channelId] = c
+		return nil
+	})
+	channelsMu.Unlock()
+
+	if err != nil && !errors.Is(err, errNotRenamed) {
+		log.Printf("write to %#v file error: %s\n", path, err.Error())
+	}
+	if err == nil {
+		s.emit(Event{
+			Type:      consts.EV_CHANNEL_RENAMED,
+			ChannelId: channelId,
+			OldTitle:  oldTitle,
+			NewTitle:  title,
+		})
 	}
-	return channels, nil
 }
 
 func (s *Storage) ReadAllPlaylists(
+	ctx context.Context,
 	channelId string,
 	update bool,
 ) (map[string]models.Playlist, error) {
@@ -89,32 +307,30 @@ func (s *Storage) ReadAllPlaylists(
 		fmt.Sprintf("%s%s%s", consts.P_PLAYLISTS, channelId, consts.EXT_JSON),
 	)
 
-	if !exists(path) || update {
-		playlists, err := s.Service.GetPlaylists(channelId)
-		if err != nil {
+	key := consts.P_PLAYLISTS + channelId
+	if !exists(path) || update || s.stale(key, s.playlistsTTL()) {
+		playlists, err := s.updatePlaylists(ctx, channelId)
+		switch {
+		case err != nil && !update && exists(path):
+			log.Printf("playlists %s refresh error, using stale cache: %s\n", channelId, err.Error())
+		case err != nil:
 			return nil, err
+		default:
+			playlistsMap := make(map[string]models.Playlist, 0)
+			for _, p := range playlists {
+				playlistsMap[p.Id] = p
+			}
+			return playlistsMap, nil
 		}
-
-		if !s.writePlaylistsToFile(channelId, playlists) {
-			return nil, errors.New("can't write playlists to file")
-		}
-
-		playlistsMap := make(map[string]models.Playlist, 0)
-		for _, p := range playlists {
-			playlistsMap[p.Id] = p
-		}
-		return playlistsMap, nil
 	}
 
 	playlists := make([]models.Playlist, 0)
-	playlistsRaw, err := ioutil.ReadFile(path)
-	if err != nil {
+	if err := cachefile.Read(path, &playlists); err != nil {
 		log.Printf("read playlists from file error: %s\n", err.Error())
-		return nil, err
-	}
-
-	if err := json.Unmarshal([]byte(playlistsRaw), &playlists); err != nil {
-		log.Printf("playlists unmarshal error: %s\n", err.Error())
+		if cachefile.Unusable(err) {
+			// corrupt file is moved aside, fetch playlists again
+			return s.ReadAllPlaylists(ctx, channelId, true)
+		}
 		return nil, err
 	}
 
@@ -126,85 +342,252 @@ func (s *Storage) ReadAllPlaylists(
 	return playlistsMap, nil
 }
 
-func (s *Storage) ReadUploads(channelId string, update bool) ([]models.Video, error) {
+// fetch playlists and their videos with stored etags, videos of playlists
+// which didn't change are taken from cache. Playlists are written to cache
+// only when anything changed
+func (s *Storage) updatePlaylists(
+	ctx context.Context,
+	channelId string,
+) ([]models.Playlist, error) {
 	path := filepath.Join(
 		s.AppConfig.CachePath,
-		fmt.Sprintf("%s%s%s", consts.P_VIDEOS, channelId, consts.EXT_JSON),
+		fmt.Sprintf("%s%s%s", consts.P_PLAYLISTS, channelId, consts.EXT_JSON),
 	)
+	key := consts.P_PLAYLISTS + channelId
 
-	if !exists(path) || update {
-		videos, err := s.Service.GetUploads(channelId)
-		if err != nil {
+	old := make([]models.Playlist, 0)
+	etag, etags := "", make(map[string]string, 0)
+	if exists(path) && cachefile.Read(path, &old) == nil {
+		etag = s.cacheMeta(key).ETag
+		etags = s.playlistEtags(channelId)
+	}
+	cached := make(map[string]models.Playlist, 0)
+	for _, p := range old {
+		cached[p.Id] = p
+	}
+
+	list, newEtag, err := s.Service.GetPlaylists(ctx, channelId, etag)
+	changed := true
+	if errors.Is(err, service.ErrNotModified) {
+		// playlists are the same, but their videos may have changed
+		list, newEtag, err, changed = old, etag, nil, false
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	playlists := make([]models.Playlist, 0, len(list))
+	newEtags := make(map[string]string, 0)
+	for _, p := range list {
+		c, ok := cached[p.Id]
+		videosEtag := ""
+		if ok {
+			videosEtag = etags[p.Id]
+		}
+		videos, tag, err := s.Service.GetVideos(ctx, p.Id, videosEtag)
+		switch {
+		case errors.Is(err, service.ErrNotModified):
+			videos, tag = c.Videos, videosEtag
+		case service.QuotaError(err):
+			// don't replace cache with incomplete playlists
 			return nil, err
+		case err != nil && ok:
+			log.Printf("can't get videos for playlist %s, using cached: %s\n", p.Id, err.Error())
+			videos, tag = c.Videos, videosEtag
+		case err != nil:
+			log.Printf("can't get videos for playlist %s: %s\n", p.Id, err.Error())
+			continue
+		default:
+			changed = true
 		}
+		p.Videos = videos
+		playlists = append(playlists, p)
+		newEtags[p.Id] = tag
+	}
 
-		if !s.writeVideosToFile(channelId, videos) {
-			return nil, errors.New("can't write videos to file")
+	if !changed {
+		s.setCacheMeta(key, etag)
+		return old, nil
+	}
+	if !s.writePlaylistsToFile(channelId, playlists) {
+		return nil, errors.New("can't write playlists to file")
+	}
+	s.setCacheMeta(key, newEtag)
+	s.setPlaylistEtags(channelId, newEtags)
+	return playlists, nil
+}
+
+func (s *Storage) ReadUploads(
+	ctx context.Context,
+	channelId string,
+	update bool,
+) ([]models.Video, error) {
+	path := filepath.Join(
+		s.AppConfig.CachePath,
+		fmt.Sprintf("%s%s%s", consts.P_VIDEOS, channelId, consts.EXT_JSON),
+	)
+
+	if !exists(path) || update {
+		videos, _, err := s.updateUploads(ctx, channelId)
+		return videos, err
+	}
+	if s.stale(consts.P_VIDEOS+channelId, s.uploadsTTL()) {
+		videos, _, err := s.updateUploads(ctx, channelId)
+		if err == nil {
+			return videos, nil
 		}
+		log.Printf("uploads %s refresh error, using stale cache: %s\n", channelId, err.Error())
+	}
 
-		return videos, nil
+	videos, err := s.readVideosFromFile(path)
+	if cachefile.Unusable(err) {
+		// corrupt file is moved aside, fetch uploads again
+		videos, _, err = s.updateUploads(ctx, channelId)
 	}
+	return videos, err
+}
 
-	videos := make([]models.Video, 0)
-	videosRaw, err := ioutil.ReadFile(path)
+// CachedUploads returns cached uploads without fetching them, stale is true
+// when cache is older than uploads ttl
+func (s *Storage) CachedUploads(channelId string) ([]models.Video, bool, error) {
+	path := filepath.Join(
+		s.AppConfig.CachePath,
+		fmt.Sprintf("%s%s%s", consts.P_VIDEOS, channelId, consts.EXT_JSON),
+	)
+	videos, err := s.readVideosFromFile(path)
 	if err != nil {
-		log.Printf("read videos from file error: %s\n", err.Error())
-		return nil, err
+		return nil, false, err
 	}
+	return videos, s.stale(consts.P_VIDEOS+channelId, s.uploadsTTL()), nil
+}
 
-	if err := json.Unmarshal([]byte(videosRaw), &videos); err != nil {
-		log.Printf("videos unmarshal error: %s\n", err.Error())
-		return nil, err
+// CachedPlaylists returns cached playlists without fetching them, stale is
+// true when cache is older than playlists ttl
+func (s *Storage) CachedPlaylists(channelId string) (map[string]models.Playlist, bool, error) {
+	path := filepath.Join(
+		s.AppConfig.CachePath,
+		fmt.Sprintf("%s%s%s", consts.P_PLAYLISTS, channelId, consts.EXT_JSON),
+	)
+	playlists := make([]models.Playlist, 0)
+	if err := cachefile.Read(path, &playlists); err != nil {
+		return nil, false, err
 	}
-	return videos, nil
+
+	playlistsMap := make(map[string]models.Playlist, 0)
+	for _, p := range playlists {
+		playlistsMap[p.Id] = p
+	}
+	return playlistsMap, s.stale(consts.P_PLAYLISTS+channelId, s.playlistsTTL()), nil
 }
 
-// read playlist videos
-func (s *Storage) ReadPlaylist(playlistId string) ([]models.Video, error) {
+// fetch uploads and write them to cache, returns fresh videos and videos
+// which were not present in previous cache
+func (s *Storage) updateUploads(
+	ctx context.Context,
+	channelId string,
+) ([]models.Video, []models.Video, error) {
 	path := filepath.Join(
 		s.AppConfig.CachePath,
-		fmt.Sprintf("%s%s%s", consts.P_VIDEOS, playlistId, consts.EXT_JSON),
+		fmt.Sprintf("%s%s%s", consts.P_VIDEOS, channelId, consts.EXT_JSON),
 	)
-	if !exists(path) {
-		videos, err := s.Service.GetVideos(playlistId)
-		if err != nil {
-			return nil, err
-		}
 
-		if !s.writeVideosToFile(playlistId, videos) {
-			return nil, errors.New("can't write videos to file")
-		}
+	// nothing is new on the first fetch
+	var old []models.Video
+	cached := exists(path)
+	if cached {
+		var err error
+		old, err = s.readVideosFromFile(path)
+		cached = err == nil
+	}
 
-		return videos, nil
+	key := consts.P_VIDEOS + channelId
+	etag := ""
+	if cached {
+		etag = s.cacheMeta(key).ETag
 	}
 
-	videos := make([]models.Video, 0)
-	videosRaw, err := ioutil.ReadFile(path)
+	videos, newEtag, err := s.Service.GetUploads(ctx, s.uploadsId(channelId), etag)
+	if errors.Is(err, service.ErrNotModified) {
+		s.setCacheMeta(key, etag)
+		return old, nil, nil
+	}
 	if err != nil {
-		log.Printf("read videos from file error: %s\n", err.Error())
-		return nil, err
+		return nil, nil, err
 	}
 
-	if err := json.Unmarshal([]byte(videosRaw), &videos); err != nil {
-		log.Printf("videos unmarshal error: %s\n", err.Error())
+	if !s.writeVideosToFile(channelId, videos) {
+		return nil, nil, errors.New("can't write videos to file")
+	}
+	s.setCacheMeta(key, newEtag)
+	s.checkRename(channelId, videos)
+
+	if !cached {
+		return videos, nil, nil
+	}
+
+	added := newVideos(old, videos)
+	if len(added) > 0 {
+		s.emit(Event{
+			Type:      consts.EV_NEW_VIDEOS,
+			ChannelId: channelId,
+			Videos:    added,
+		})
+	}
+	return videos, added, nil
+}
+
+func (s *Storage) readVideosFromFile(path string) ([]models.Video, error) {
+	videos := make([]models.Video, 0)
+	if err := cachefile.Read(path, &videos); err != nil {
+		log.Printf("read videos from file error: %s\n", err.Error())
 		return nil, err
 	}
 	return videos, nil
 }
 
-func (s *Storage) writeChannelsToFile(channels map[string]models.Channel) bool {
-	path := filepath.Join(s.AppConfig.CachePath, channelsFile)
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
-	defer file.Close()
-	if err != nil {
-		log.Printf("open channels.json file error: %s\n", err.Error())
-		return false
+// read playlist videos, they are refreshed with playlists ttl
+func (s *Storage) ReadPlaylist(ctx context.Context, playlistId string) ([]models.Video, error) {
+	path := filepath.Join(
+		s.AppConfig.CachePath,
+		fmt.Sprintf("%s%s%s", consts.P_VIDEOS, playlistId, consts.EXT_JSON),
+	)
+	key := consts.P_VIDEOS + playlistId
+	if !exists(path) || s.stale(key, s.playlistsTTL()) {
+		etag := ""
+		if exists(path) {
+			etag = s.cacheMeta(key).ETag
+		}
+		videos, newEtag, err := s.Service.GetVideos(ctx, playlistId, etag)
+		switch {
+		case errors.Is(err, service.ErrNotModified):
+			s.setCacheMeta(key, etag)
+		case err != nil && exists(path):
+			log.Printf("playlist %s refresh error, using stale cache: %s\n", playlistId, err.Error())
+		case err != nil:
+			return nil, err
+		default:
+			if !s.writeVideosToFile(playlistId, videos) {
+				return nil, errors.New("can't write videos to file")
+			}
+			s.setCacheMeta(key, newEtag)
+			return videos, nil
+		}
 	}
 
-	if err := json.NewEncoder(file).Encode(&channels); err != nil {
-		log.Printf("write to channels.json file error: %s\n", err.Error())
+	videos, err := s.readVideosFromFile(path)
+	if cachefile.Unusable(err) {
+		// corrupt file is moved aside, so it's fetched again
+		return s.ReadPlaylist(ctx, playlistId)
 	}
+	return videos, err
+}
 
+func (s *Storage) writeChannelsToFile(channels map[string]models.Channel) bool {
+	path := filepath.Join(s.AppConfig.CachePath, channelsFile)
+	if err := cachefile.Write(path, &channels); err != nil {
+		log.Printf("write to %#v file error: %s\n", path, err.Error())
+		return false
+	}
 	return true
 }
 
@@ -213,14 +596,7 @@ func (s *Storage) writePlaylistsToFile(channelId string, playlists []models.Play
 		s.AppConfig.CachePath,
 		fmt.Sprintf("%s%s%s", consts.P_PLAYLISTS, channelId, consts.EXT_JSON),
 	)
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
-	defer file.Close()
-	if err != nil {
-		log.Printf("open %#v file error: %s\n", path, err.Error())
-		return false
-	}
-
-	if err := json.NewEncoder(file).Encode(&playlists); err != nil {
+	if err := cachefile.Write(path, &playlists); err != nil {
 		log.Printf("write to %#v file error: %s\n", path, err.Error())
 		return false
 	}
@@ -232,14 +608,7 @@ func (s *Storage) writeVideosToFile(channelId string, videos []models.Video) boo
 		s.AppConfig.CachePath,
 		fmt.Sprintf("%s%s%s", consts.P_VIDEOS, channelId, consts.EXT_JSON),
 	)
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
-	defer file.Close()
-	if err != nil {
-		log.Printf("open %#v file error: %s\n", path, err.Error())
-		return false
-	}
-
-	if err := json.NewEncoder(file).Encode(&videos); err != nil {
+	if err := cachefile.Write(path, &videos); err != nil {
 		log.Printf("write to %#v file error: %s\n", path, err.Error())
 		return false
 	}
@@ -0,0 +1,54 @@
+//go:build linux
+
+package tui
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// sent to the process when terminal window size changes
+var resizeSignal os.Signal = unix.SIGWINCH
+
+type terminal struct {
+	fd  int
+	old unix.Termios
+}
+
+// switch terminal to raw mode, original state is restored by restore()
+func openTerminal(f *os.File) (*terminal, error) {
+	fd := int(f.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &terminal{fd: fd, old: *termios}
+	raw := *termios
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+		unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *terminal) restore() error {
+	return unix.IoctlSetTermios(t.fd, unix.TCSETS, &t.old)
+}
+
+// returns terminal width and height
+func (t *terminal) size() (int, int, error) {
+	ws, err := unix.IoctlGetWinsize(t.fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
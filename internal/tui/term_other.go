@@ -0,0 +1,24 @@
+//go:build !linux
+
+package tui
+
+import (
+	"errors"
+	"os"
+)
+
+var resizeSignal os.Signal
+
+type terminal struct{}
+
+func openTerminal(f *os.File) (*terminal, error) {
+	return nil, errors.New("tui mode is supported only on linux")
+}
+
+func (t *terminal) restore() error {
+	return nil
+}
+
+func (t *terminal) size() (int, int, error) {
+	return 0, 0, errors.New("tui mode is supported only on linux")
+}
@@ -0,0 +1,874 @@
+package tui
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"html"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/su55y/yt_feed/internal/consts"
+	"github.com/su55y/yt_feed/internal/downloads"
+	"github.com/su55y/yt_feed/internal/jobs"
+	"github.com/su55y/yt_feed/internal/models"
+	"github.com/su55y/yt_feed/internal/player"
+	"github.com/su55y/yt_feed/internal/service"
+	"github.com/su55y/yt_feed/internal/storage"
+)
+
+// keys of background updates
+const (
+	jobUpdateAll = "update"
+	jobVideos    = "videos:"
+	jobPlaylists = "playlists:"
+)
+
+// new videos events received while queue is full are not counted
+const eventsSize = 100
+
+type pane int
+
+const (
+	paneChannels pane = iota
+	paneVideos
+	paneDetail
+)
+
+// what is listed in the videos pane
+type listMode int
+
+const (
+	modeUploads listMode = iota
+	modePlaylists
+	modePlaylist
+)
+
+type key int
+
+const (
+	keyRune key = iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyEnter
+	keyTab
+	keyBackspace
+	keyEsc
+	keyCtrlC
+)
+
+type keyEvent struct {
+	key key
+	r   rune
+}
+
+// single entry of the videos pane, either video or playlist
+type item struct {
+	id    string
+	title string
+	icon  string
+}
+
+func (i item) video() models.Video {
+	return models.Video{Id: i.id, Title: i.title, ThumbnailPath: i.icon}
+}
+
+type TUI struct {
+	ctx       context.Context
+	stor      *storage.Storage
+	player    *player.Player
+	downloads *downloads.Manager
+
+	channels  []models.Channel
+	items     []item
+	playlists map[string]models.Playlist
+	history   map[string]models.HistoryEntry
+	mode      listMode
+	listTitle string
+
+	focus     pane
+	chCursor  int
+	chOffset  int
+	itCursor  int
+	itOffset  int
+	message   string
+	width     int
+	height    int
+	term      *terminal
+	out       *bufio.Writer
+	keys      chan keyEvent
+	readError chan error
+	// quit was requested while downloads are running
+	quitWarned bool
+
+	// updates run in background, so keys are read while they run.
+	// jobsCtx is canceled on quit
+	jobs       jobs.Registry
+	jobsCtx    context.Context
+	cancelJobs context.CancelFunc
+	updating   int
+	// new videos found since update of all channels was started
+	newVideos int
+	events    chan storage.Event
+}
+
+// New should be called before any update is started, see storage.Subscribe
+func New(stor *storage.Storage, p *player.Player, dl *downloads.Manager) TUI {
+	events := make(chan storage.Event, eventsSize)
+	stor.Subscribe(func(e storage.Event) {
+		if e.Type != consts.EV_NEW_VIDEOS {
+			return
+		}
+		select {
+		case events <- e:
+		default:
+		}
+	})
+	return TUI{
+		stor:      stor,
+		player:    p,
+		downloads: dl,
+		out:       bufio.NewWriter(os.Stdout),
+		jobs:      jobs.New(),
+		events:    events,
+	}
+}
+
+// Run blocks until user quits or ctx is canceled, ctx also cancels running
+// updates
+func (t *TUI) Run(ctx context.Context) error {
+	t.ctx = ctx
+	t.jobsCtx, t.cancelJobs = context.WithCancel(ctx)
+	defer func() { t.cancelJobs() }()
+	channels, err := t.stor.ReadChannels(ctx)
+	if err != nil {
+		return err
+	}
+	t.setChannels(channels)
+
+	if t.term, err = openTerminal(os.Stdin); err != nil {
+		return err
+	}
+	defer t.term.restore()
+
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+		t.out.Flush()
+	}()
+
+	resize := make(chan os.Signal, 1)
+	if resizeSignal != nil {
+		signal.Notify(resize, resizeSignal)
+		defer signal.Stop(resize)
+	}
+
+	t.keys = make(chan keyEvent)
+	t.readError = make(chan error, 1)
+	go t.readKeys()
+
+	t.message = fmt.Sprintf("%d channels", len(t.channels))
+	t.render()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-resize:
+			t.render()
+		case err := <-t.readError:
+			return err
+		case e := <-t.events:
+			t.newVideos += len(e.Videos)
+		case r := <-t.jobs.Done():
+			t.finished(r)
+			t.render()
+		case ev := <-t.keys:
+			if !t.handle(ev) {
+				return nil
+			}
+			t.render()
+		}
+	}
+}
+
+func (t *TUI) setChannels(channels map[string]models.Channel) {
+	t.channels = make([]models.Channel, 0, len(channels))
+	for _, c := range channels {
+		t.channels = append(t.channels, c)
+	}
+	sort.Slice(t.channels, func(i, j int) bool {
+		return strings.ToLower(t.channels[i].Title) < strings.ToLower(t.channels[j].Title)
+	})
+	if t.chCursor >= len(t.channels) {
+		t.chCursor = 0
+	}
+}
+
+func (t *TUI) readKeys() {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			t.readError <- err
+			return
+		}
+		for _, ev := range parseKeys(buf[:n]) {
+			t.keys <- ev
+		}
+	}
+}
+
+func parseKeys(b []byte) []keyEvent {
+	events := make([]keyEvent, 0)
+	for len(b) > 0 {
+		switch {
+		case len(b) >= 3 && b[0] == 0x1b && (b[1] == '[' || b[1] == 'O'):
+			switch b[2] {
+			case 'A':
+				events = append(events, keyEvent{key: keyUp})
+			case 'B':
+				events = append(events, keyEvent{key: keyDown})
+			case 'C':
+				events = append(events, keyEvent{key: keyRight})
+			case 'D':
+				events = append(events, keyEvent{key: keyLeft})
+			}
+			b = b[3:]
+			continue
+		case b[0] == 0x1b:
+			events = append(events, keyEvent{key: keyEsc})
+		case b[0] == '\r' || b[0] == '\n':
+			events = append(events, keyEvent{key: keyEnter})
+		case b[0] == '\t':
+			events = append(events, keyEvent{key: keyTab})
+		case b[0] == 0x7f || b[0] == 0x08:
+			events = append(events, keyEvent{key: keyBackspace})
+		case b[0] == 0x03:
+			events = append(events, keyEvent{key: keyCtrlC})
+		default:
+			events = append(events, keyEvent{key: keyRune, r: rune(b[0])})
+		}
+		b = b[1:]
+	}
+	return events
+}
+
+// returns false when user wants to quit
+func (t *TUI) handle(ev keyEvent) bool {
+	switch ev.key {
+	case keyCtrlC:
+		return t.quit()
+	case keyUp:
+		t.move(-1)
+	case keyDown:
+		t.move(1)
+	case keyLeft:
+		t.focusPane(t.focus - 1)
+	case keyRight, keyTab:
+		t.focusPane(t.focus + 1)
+	case keyEnter:
+		t.enter()
+	case keyBackspace, keyEsc:
+		t.back()
+	case keyRune:
+		switch ev.r {
+		case 'q':
+			return t.quit()
+		case 'k':
+			t.move(-1)
+		case 'j':
+			t.move(1)
+		case 'g':
+			t.move(-len(t.channels) - len(t.items))
+		case 'G':
+			t.move(len(t.channels) + len(t.items))
+		case 'h':
+			t.focusPane(t.focus - 1)
+		case 'l':
+			t.focusPane(t.focus + 1)
+		case 'v':
+			t.showUploads(false)
+		case 'p':
+			t.showPlaylists(false)
+		case 's':
+			t.playFromStart()
+		case 'd':
+			t.download()
+		case 'a':
+			t.enqueue()
+		case 'A':
+			t.playAll()
+		case 'r':
+			t.refresh()
+		case 'R':
+			t.refreshAll()
+		}
+	}
+	return true
+}
+
+// returns false when it's ok to quit. Running updates are canceled first,
+// downloads are stopped on quit so user is warned about them
+func (t *TUI) quit() bool {
+	if t.updating > 0 && t.jobsCtx.Err() == nil {
+		t.cancelJobs()
+		t.message = "canceling updates, press q again to quit"
+		return true
+	}
+	if n := len(t.downloads.Active()); n > 0 && !t.quitWarned {
+		t.quitWarned = true
+		t.message = fmt.Sprintf("%d downloads in progress, press q again to quit", n)
+		return true
+	}
+	return false
+}
+
+func (t *TUI) focusPane(p pane) {
+	if p < paneChannels || p > paneDetail {
+		return
+	}
+	if p != paneChannels && len(t.items) == 0 {
+		return
+	}
+	t.focus = p
+}
+
+func (t *TUI) move(delta int) {
+	switch t.focus {
+	case paneChannels:
+		cursor := clamp(t.chCursor+delta, len(t.channels))
+		if cursor != t.chCursor {
+			// listed items belong to previously selected channel
+			t.chCursor = cursor
+			t.clearItems()
+		}
+	case paneVideos, paneDetail:
+		t.itCursor = clamp(t.itCursor+delta, len(t.items))
+	}
+}
+
+// empties videos pane and moves focus to channels
+func (t *TUI) clearItems() {
+	t.items = nil
+	t.playlists = nil
+	t.mode = modeUploads
+	t.listTitle = ""
+	t.itCursor, t.itOffset = 0, 0
+	t.focus = paneChannels
+}
+
+func (t *TUI) enter() {
+	switch t.focus {
+	case paneChannels:
+		t.showUploads(false)
+		if len(t.items) > 0 {
+			t.focus = paneVideos
+		}
+	case paneVideos, paneDetail:
+		if t.itCursor >= len(t.items) {
+			return
+		}
+		it := t.items[t.itCursor]
+		if t.mode == modePlaylists {
+			t.showPlaylist(it.id)
+			return
+		}
+		t.playVideo(it, true)
+	}
+}
+
+// play video from resume point if it has one and resume is true
+func (t *TUI) playVideo(it item, resume bool) {
+	var start float64
+	if entry := t.history[it.id]; resume && entry.Resumable() {
+		start = entry.Position
+	}
+	if err := t.player.PlayFrom(start, it.video()); err != nil {
+		t.message = "can't play " + it.title
+	} else if start > 0 {
+		t.message = fmt.Sprintf("resuming %s at %s", it.title, player.FormatPosition(start))
+	} else {
+		t.message = "playing " + it.title
+	}
+}
+
+func (t *TUI) playFromStart() {
+	if t.focus == paneChannels || t.mode == modePlaylists || t.itCursor >= len(t.items) {
+		return
+	}
+	t.playVideo(t.items[t.itCursor], false)
+}
+
+func (t *TUI) download() {
+	if t.focus == paneChannels || t.mode == modePlaylists || t.itCursor >= len(t.items) {
+		return
+	}
+	c, _ := t.currentChannel()
+	it := t.items[t.itCursor]
+	if err := t.downloads.Enqueue(it.video(), c.Id, consts.PRESET_BEST); err != nil {
+		t.message = "download error: " + err.Error()
+	} else {
+		t.message = "downloading " + it.title
+	}
+}
+
+func (t *TUI) enqueue() {
+	if t.focus == paneChannels || t.mode == modePlaylists || t.itCursor >= len(t.items) {
+		return
+	}
+	it := t.items[t.itCursor]
+	if n, err := t.stor.Enqueue(it.video()); err != nil {
+		t.message = "can't enqueue video: " + err.Error()
+	} else {
+		t.message = fmt.Sprintf("queued %s (%d in queue)", it.title, n)
+	}
+}
+
+// play all listed videos, or the queue when nothing is listed
+func (t *TUI) playAll() {
+	videos := make([]models.Video, 0, len(t.items))
+	if t.mode != modePlaylists {
+		for _, it := range t.items {
+			videos = append(videos, it.video())
+		}
+	}
+	fromQueue := len(videos) == 0
+	if fromQueue {
+		queue, err := t.stor.ReadQueue()
+		if err != nil {
+			t.message = "read queue error: " + err.Error()
+			return
+		}
+		videos = queue
+	}
+
+	if err := t.player.Play(videos...); err != nil {
+		t.message = "play error: " + err.Error()
+		return
+	}
+	t.message = fmt.Sprintf("playing %d videos", len(videos))
+	if fromQueue {
+		if err := t.stor.ClearQueue(); err != nil {
+			t.message = "clear queue error: " + err.Error()
+		}
+	}
+}
+
+func (t *TUI) back() {
+	switch {
+	case t.mode == modePlaylist:
+		t.showPlaylists(false)
+	case t.focus != paneChannels:
+		t.focus = paneChannels
+	}
+}
+
+func (t *TUI) currentChannel() (models.Channel, bool) {
+	if t.chCursor >= len(t.channels) {
+		return models.Channel{}, false
+	}
+	return t.channels[t.chCursor], true
+}
+
+// lists cached uploads, they are updated in background when cache is
+// missing or stale, or when update is true
+func (t *TUI) showUploads(update bool) {
+	c, ok := t.currentChannel()
+	if !ok {
+		return
+	}
+	t.mode = modeUploads
+	t.listTitle = c.Title + " uploads"
+	stale, err := t.listUploads(c)
+	switch {
+	case err != nil:
+		t.items, t.itCursor, t.itOffset, t.focus = nil, 0, 0, paneChannels
+		t.updateVideos(c)
+		t.message = "loading videos of " + c.Title + "..."
+	case update:
+		t.updateVideos(c)
+		t.message = "updating " + c.Title + "..."
+	case stale:
+		t.updateVideos(c)
+		t.message += " · updating"
+	}
+}
+
+// lists cached uploads of channel, reports whether they are stale
+func (t *TUI) listUploads(c models.Channel) (bool, error) {
+	videos, stale, err := t.stor.CachedUploads(c.Id)
+	if err != nil {
+		return false, err
+	}
+
+	t.items = make([]item, 0, len(videos))
+	for _, v := range videos {
+		if !v.Private() {
+			t.items = append(t.items, item{id: v.Id, title: v.Title, icon: v.ThumbnailPath})
+		}
+	}
+	t.loadHistory()
+	t.itCursor, t.itOffset = 0, 0
+	t.message = fmt.Sprintf("last %d videos of %s", len(t.items), c.Title)
+	return stale, nil
+}
+
+func (t *TUI) updateVideos(c models.Channel) {
+	stor := t.stor
+	t.start(jobVideos+c.Id, func(ctx context.Context) error {
+		_, err := stor.ReadUploads(ctx, c.Id, true)
+		return err
+	})
+}
+
+func (t *TUI) loadHistory() {
+	history, err := t.stor.ReadHistory()
+	if err != nil {
+		history = map[string]models.HistoryEntry{}
+	}
+	t.history = history
+}
+
+// lists cached playlists, they are updated in background the same way as
+// uploads, see showUploads
+func (t *TUI) showPlaylists(update bool) {
+	c, ok := t.currentChannel()
+	if !ok {
+		return
+	}
+	t.mode = modePlaylists
+	t.listTitle = c.Title + " playlists"
+	stale, err := t.listPlaylists(c)
+	switch {
+	case err != nil:
+		t.items, t.playlists = nil, nil
+		t.itCursor, t.itOffset, t.focus = 0, 0, paneChannels
+		t.updatePlaylists(c)
+		t.message = "loading playlists of " + c.Title + "..."
+	case update:
+		t.updatePlaylists(c)
+		t.message = "updating " + c.Title + "..."
+	case stale:
+		t.updatePlaylists(c)
+		t.message += " · updating"
+	}
+	if len(t.items) > 0 {
+		t.focus = paneVideos
+	}
+}
+
+// lists cached playlists of channel, reports whether they are stale
+func (t *TUI) listPlaylists(c models.Channel) (bool, error) {
+	playlists, stale, err := t.stor.CachedPlaylists(c.Id)
+	if err != nil {
+		return false, err
+	}
+
+	t.playlists = playlists
+	t.items = make([]item, 0, len(playlists))
+	for _, p := range playlists {
+		t.items = append(t.items, item{id: p.Id, title: p.Title, icon: p.ThumbnailPath})
+	}
+	sort.Slice(t.items, func(i, j int) bool { return t.items[i].title < t.items[j].title })
+	t.itCursor, t.itOffset = 0, 0
+	t.message = fmt.Sprintf("last %d playlists of %s", len(t.items), c.Title)
+	return stale, nil
+}
+
+func (t *TUI) updatePlaylists(c models.Channel) {
+	stor := t.stor
+	t.start(jobPlaylists+c.Id, func(ctx context.Context) error {
+		_, err := stor.ReadAllPlaylists(ctx, c.Id, true)
+		return err
+	})
+}
+
+func (t *TUI) showPlaylist(playlistId string) {
+	p, ok := t.playlists[playlistId]
+	if !ok {
+		return
+	}
+	t.items = make([]item, 0, len(p.Videos))
+	for _, v := range p.Videos {
+		if !v.Private() {
+			t.items = append(t.items, item{id: v.Id, title: v.Title, icon: v.ThumbnailPath})
+		}
+	}
+	t.mode = modePlaylist
+	t.loadHistory()
+	t.listTitle = p.Title
+	t.itCursor, t.itOffset = 0, 0
+	t.message = fmt.Sprintf("last %d videos of %s playlist", len(t.items), p.Title)
+}
+
+// update videos or playlists of the current channel
+func (t *TUI) refresh() {
+	if t.mode == modeUploads {
+		t.showUploads(true)
+	} else {
+		t.showPlaylists(true)
+	}
+}
+
+func (t *TUI) refreshAll() {
+	stor := t.stor
+	started := t.start(jobUpdateAll, func(ctx context.Context) error {
+		channels, err := stor.ReadChannels(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = stor.UpdateAll(ctx, channels, true)
+		return err
+	})
+	if started {
+		t.newVideos = 0
+		t.message = "updating..."
+	}
+}
+
+// runs update in background, canceled updates are started with new context
+func (t *TUI) start(key string, fn func(context.Context) error) bool {
+	if t.jobsCtx.Err() != nil {
+		t.jobsCtx, t.cancelJobs = context.WithCancel(t.ctx)
+	}
+	if !t.jobs.Start(t.jobsCtx, key, fn) {
+		return false
+	}
+	t.updating++
+	return true
+}
+
+// shows result of finished update, shown list is reloaded from cache
+func (t *TUI) finished(r jobs.Result) {
+	t.updating--
+	c, _ := t.currentChannel()
+	switch {
+	case r.Key == jobUpdateAll:
+		// events are emitted before update finishes
+		for len(t.events) > 0 {
+			e := <-t.events
+			t.newVideos += len(e.Videos)
+		}
+		if channels, err := t.stor.CachedChannels(); err == nil {
+			t.setChannels(channels)
+			t.selectChannel(c.Id)
+		}
+		t.reload()
+		t.message = fmt.Sprintf(
+			"updating...done, %d new videos · %s",
+			t.newVideos,
+			t.stor.Service.Quota.String(),
+		)
+		if r.Err != nil {
+			t.message += " · " + service.UserMessage(r.Err)
+		}
+	case r.Key == jobVideos+c.Id && t.mode == modeUploads && len(t.listTitle) > 0,
+		r.Key == jobPlaylists+c.Id && t.mode != modeUploads && len(t.listTitle) > 0:
+		t.reload()
+		if r.Err != nil {
+			t.message = fmt.Sprintf("%s not updated: %s", c.Title, service.UserMessage(r.Err))
+		} else {
+			t.message += " · updated"
+		}
+	}
+}
+
+// moves channels cursor to channel, channels may be reordered by renames
+func (t *TUI) selectChannel(channelId string) {
+	for i, c := range t.channels {
+		if c.Id == channelId {
+			t.chCursor = i
+			return
+		}
+	}
+	t.clearItems()
+}
+
+// lists shown uploads or playlists again from cache, cursor stays in place
+func (t *TUI) reload() {
+	c, ok := t.currentChannel()
+	if !ok || len(t.listTitle) == 0 {
+		return
+	}
+	cursor, focus := t.itCursor, t.focus
+	var err error
+	switch t.mode {
+	case modeUploads:
+		_, err = t.listUploads(c)
+	case modePlaylists:
+		_, err = t.listPlaylists(c)
+	case modePlaylist:
+		// shown playlist stays as is, its fresh videos are listed on enter
+		if playlists, _, err := t.stor.CachedPlaylists(c.Id); err == nil {
+			t.playlists = playlists
+		}
+		return
+	}
+	if err != nil {
+		t.message = fmt.Sprintf("videos for %s not ready: %s", c.Title, service.UserMessage(err))
+		return
+	}
+	t.itCursor, t.focus = clamp(cursor, len(t.items)), focus
+	if len(t.items) == 0 {
+		t.focus = paneChannels
+	}
+}
+
+func (t *TUI) render() {
+	if w, h, err := t.term.size(); err == nil {
+		t.width, t.height = w, h
+	}
+	if t.width < 20 || t.height < 5 {
+		return
+	}
+
+	chWidth := t.width / 4
+	itWidth := t.width * 2 / 5
+	dtWidth := t.width - chWidth - itWidth - 2
+	rows := t.height - 2
+
+	t.chOffset = scroll(t.chCursor, t.chOffset, rows)
+	t.itOffset = scroll(t.itCursor, t.itOffset, rows)
+
+	detail := t.detailLines(dtWidth)
+
+	fmt.Fprint(t.out, "\x1b[H\x1b[2J")
+	fmt.Fprint(t.out, header("channels", chWidth, t.focus == paneChannels), "│",
+		header(t.listTitle, itWidth, t.focus == paneVideos), "│",
+		header("details", dtWidth, t.focus == paneDetail), "\r\n")
+
+	for row := 0; row < rows; row++ {
+		fmt.Fprint(t.out, t.channelCell(t.chOffset+row, chWidth), "│")
+		fmt.Fprint(t.out, t.itemCell(t.itOffset+row, itWidth), "│")
+		if row < len(detail) {
+			fmt.Fprint(t.out, fit(detail[row], dtWidth))
+		}
+		fmt.Fprint(t.out, "\r\n")
+	}
+
+	status := t.message + "  [j/k] move [h/l] pane [enter/s] play/from start [a/A] enqueue/play all [d] download [v/p] videos/playlists [r/R] refresh [q] quit"
+	fmt.Fprint(t.out, "\x1b[7m", fit(status, t.width), "\x1b[0m")
+	t.out.Flush()
+}
+
+func (t *TUI) channelCell(i, width int) string {
+	if i >= len(t.channels) {
+		return fit("", width)
+	}
+	return cell(html.UnescapeString(t.channels[i].Title), width, i == t.chCursor, t.focus == paneChannels)
+}
+
+func (t *TUI) itemCell(i, width int) string {
+	if i >= len(t.items) {
+		return fit("", width)
+	}
+	return cell(html.UnescapeString(t.items[i].title), width, i == t.itCursor, t.focus != paneChannels)
+}
+
+func (t *TUI) detailLines(width int) []string {
+	lines := make([]string, 0)
+	if t.focus == paneChannels || len(t.items) == 0 {
+		c, ok := t.currentChannel()
+		if !ok {
+			return lines
+		}
+		lines = append(lines, wrap(html.UnescapeString(c.Title), width)...)
+		lines = append(lines, "", "id: "+c.Id,
+			"url: https://www.youtube.com/channel/"+c.Id,
+			"icon: "+c.ThumbnailPath)
+		return lines
+	}
+
+	it := t.items[t.itCursor]
+	lines = append(lines, wrap(html.UnescapeString(it.title), width)...)
+	lines = append(lines, "", "id: "+it.id)
+	if t.mode == modePlaylists {
+		lines = append(lines,
+			"url: https://www.youtube.com/playlist?list="+it.id,
+			fmt.Sprintf("videos: %d", len(t.playlists[it.id].Videos)))
+	} else {
+		lines = append(lines, "url: https://www.youtube.com/watch?v="+it.id)
+		switch entry := t.history[it.id]; {
+		case entry.Watched:
+			lines = append(lines, "watched")
+		case entry.Resumable():
+			lines = append(lines, "resume at "+player.FormatPosition(entry.Position))
+		}
+	}
+	lines = append(lines, "icon: "+it.icon)
+	return lines
+}
+
+func header(title string, width int, focused bool) string {
+	if focused {
+		return "\x1b[1m" + fit(" "+title, width) + "\x1b[0m"
+	}
+	return fit(" "+title, width)
+}
+
+func cell(text string, width int, selected, focused bool) string {
+	switch {
+	case selected && focused:
+		return "\x1b[7m" + fit(" "+text, width) + "\x1b[0m"
+	case selected:
+		return "\x1b[4m" + fit(" "+text, width) + "\x1b[0m"
+	default:
+		return fit(" "+text, width)
+	}
+}
+
+// pad or truncate s to exactly width runes
+func fit(s string, width int) string {
+	r := []rune(s)
+	if len(r) > width {
+		if width > 1 {
+			return string(r[:width-1]) + "…"
+		}
+		return string(r[:width])
+	}
+	return s + strings.Repeat(" ", width-len(r))
+}
+
+func wrap(s string, width int) []string {
+	lines := make([]string, 0)
+	line := ""
+	for _, w := range strings.Fields(s) {
+		if len([]rune(line))+len([]rune(w))+1 > width && len(line) > 0 {
+			lines = append(lines, line)
+			line = ""
+		}
+		if len(line) > 0 {
+			line += " "
+		}
+		line += w
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func clamp(i, n int) int {
+	if i >= n {
+		i = n - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+// returns offset which keeps cursor visible
+func scroll(cursor, offset, rows int) int {
+	if cursor < offset {
+		return cursor
+	}
+	if cursor >= offset+rows {
+		return cursor - rows + 1
+	}
+	return offset
+}
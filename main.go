@@ -4,21 +4,32 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/su55y/yt_feed/internal/blocks"
 	"github.com/su55y/yt_feed/internal/config"
 	"github.com/su55y/yt_feed/internal/consts"
+	"github.com/su55y/yt_feed/internal/downloads"
+	"github.com/su55y/yt_feed/internal/hooks"
+	"github.com/su55y/yt_feed/internal/jobs"
 	"github.com/su55y/yt_feed/internal/models"
+	"github.com/su55y/yt_feed/internal/notifier"
+	"github.com/su55y/yt_feed/internal/placeholder"
+	"github.com/su55y/yt_feed/internal/player"
 	"github.com/su55y/yt_feed/internal/service"
 	"github.com/su55y/yt_feed/internal/storage"
+	"github.com/su55y/yt_feed/internal/tui"
+	"github.com/su55y/yt_feed/pkg/downloader"
 	"google.golang.org/api/youtube/v3"
 )
 
@@ -38,6 +49,26 @@ type Config struct {
 	ConfFullPath  string
 }
 
+// shown views, channel views are suffixed with channel id
+const (
+	viewOther     = ""
+	viewChannels  = "channels"
+	viewChannel   = "channel:"
+	viewUploads   = "uploads:"
+	viewPlaylists = "playlists:"
+)
+
+// background jobs keys, refresh jobs are suffixed with channel id
+const (
+	jobUpdateAll = "update"
+	jobVideos    = "videos:"
+	jobPlaylists = "playlists:"
+	jobIcons     = "icons"
+)
+
+// lines around active entry for which missing icons are fetched
+const screenful = 15
+
 func exists(path string) bool {
 	_, err := os.Stat(path)
 	return !errors.Is(err, os.ErrNotExist) && err == nil
@@ -131,14 +162,124 @@ func init() {
 	}
 }
 
-func openInMPV(id string) bool {
-	c := exec.Command("mpv", "https://www.youtube.com/watch?v="+id)
-	if err := c.Start(); err != nil {
-		log.Println(err.Error())
-		return false
+// returns buffered video by id or bare video if it's not found
+func findVideo(videos []models.Video, id string) models.Video {
+	for _, v := range videos {
+		if v.Id == id {
+			return v
+		}
+	}
+	return models.Video{Id: id}
+}
+
+func readHistory(stor *storage.Storage) map[string]models.HistoryEntry {
+	history, err := stor.ReadHistory()
+	if err != nil {
+		return map[string]models.HistoryEntry{}
+	}
+	return history
+}
+
+// decodes rofi-blocks events, decoding error means that rofi is closed
+// inputs is closed when rofi closes stdin
+func readInput(inputs chan<- models.BlocksIn) {
+	decoder := json.NewDecoder(os.Stdin)
+	for {
+		var in models.BlocksIn
+		if err := decoder.Decode(&in); err != nil {
+			log.Printf("input decoding error: %s\n", err.Error())
+			close(inputs)
+			return
+		}
+		inputs <- in
+	}
+}
+
+// cache maintenance command: stats, gc, verify or clear [--channel id]
+func runCache(stor *storage.Storage, args []string) error {
+	if len(args) == 0 {
+		return errors.New(consts.ERR_CACHE_USAGE)
+	}
+	switch args[0] {
+	case consts.CACHE_STATS:
+		st, err := stor.CacheStats()
+		if err != nil {
+			return err
+		}
+		for _, kind := range []string{
+			storage.KindChannels,
+			storage.KindUploads,
+			storage.KindPlaylists,
+			storage.KindThumbnails,
+			storage.KindOther,
+		} {
+			fmt.Printf("%-12s %6d files %10s\n", kind, st.Files[kind], formatSize(st.Bytes[kind]))
+		}
+		files, size := st.Total()
+		fmt.Printf("%-12s %6d files %10s\n", "total", files, formatSize(size))
+		fmt.Printf("%-12s %6d files %10s\n", "orphaned", st.Orphaned, formatSize(st.OrphanedBytes))
+	case consts.CACHE_GC:
+		removed, freed, err := stor.CacheGC()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("removed %d files, freed %s\n", removed, formatSize(freed))
+	case consts.CACHE_VERIFY:
+		problems, err := stor.CacheVerify()
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("found %d problems", len(problems))
+		}
+		fmt.Println("cache is ok")
+	case consts.CACHE_CLEAR:
+		flags := flag.NewFlagSet(consts.CACHE_CLEAR, flag.ExitOnError)
+		channelId := flags.String("channel", "", "clear only cache of channel with this id")
+		flags.Parse(args[1:])
+		if err := stor.CacheClear(*channelId); err != nil {
+			return err
+		}
+		fmt.Println("cache cleared")
+	default:
+		return errors.New(consts.ERR_CACHE_USAGE)
+	}
+	return nil
+}
+
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
 	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+func channelMenu(stor *storage.Storage, channelId string) []models.Line {
+	return blocks.PrintChannelMenu(
+		channelId,
+		stor.UploadsFetched(channelId),
+		stor.PlaylistsFetched(channelId),
+	)
+}
 
-	return c.Process.Pid > 0
+func menuExtras(stor *storage.Storage, dl *downloads.Manager) blocks.Extras {
+	extras := blocks.Extras{Downloads: len(dl.Active())}
+	if queue, err := stor.ReadQueue(); err == nil {
+		extras.Queued = len(queue)
+	}
+	if finished, err := stor.ReadDownloads(); err == nil {
+		extras.Downloads += len(finished)
+	}
+	return extras
 }
 
 type PlaylistBuffer struct {
@@ -170,155 +311,584 @@ func main() {
 
 	blocksOutput := models.Blocks{}
 
-	ytService := service.New(context.Background(), &appConf)
+	// menus cancel running updates and quit on first signal, second one
+	// kills the process. Other commands keep default signal handling
+	ctx := context.Background()
+	if len(os.Args) == 1 || os.Args[1] == consts.CMD_TUI {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go func() {
+			<-ctx.Done()
+			stop()
+		}()
+	}
+
+	downloader.SetConcurrency(appConf.Concurrency)
+	placeholder.SetDir(filepath.Join(appConf.ThumbDir, consts.PLACEHOLDER_DIR_NAME))
+	ytService := service.New(ctx, &appConf)
+	defer ytService.Quota.Flush()
 	stor := storage.New(&appConf, &ytService)
+	mpv := player.New(&appConf, &stor)
+	dl := downloads.New(&appConf, &stor)
+	stor.Subscribe(dl.HandleEvent)
+	hooksRunner := hooks.New(ctx, &appConf.Hooks)
+	stor.Subscribe(hooksRunner.HandleEvent)
+	if appConf.Notifications.Enabled {
+		if n, err := notifier.New(&appConf.Notifications); err != nil {
+			log.Printf("notifier error: %s\n", err.Error())
+		} else {
+			notifications := notifier.NewListener(ctx, n, &appConf.Notifications, &stor)
+			stor.Subscribe(notifications.HandleEvent)
+		}
+	}
 
-	channels, err := stor.ReadChannels()
-	if err != nil {
-		log.Fatal(err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case consts.CMD_TUI:
+			t := tui.New(&stor, &mpv, dl)
+			if err := t.Run(ctx); err != nil {
+				log.Fatalf("tui error: %s", err.Error())
+			}
+		case consts.CMD_PLAY:
+			start, args, err := player.ParseArgs(os.Args[2:])
+			if err != nil {
+				os.Exit(2)
+			}
+			if err := mpv.Run(start, args...); err != nil {
+				log.Printf("mpv error: %s", err.Error())
+			}
+		case consts.CMD_CACHE:
+			if err := runCache(&stor, os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "cache: %s\n", err.Error())
+				os.Exit(1)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, consts.ERR_UNKNOWN_CMD+"\n", os.Args[1])
+			os.Exit(2)
+		}
+		return
 	}
 
-	blocksOutput.Lines = blocks.PrintChannels(channels, true)
-	blocksOutput.Message = "updating..."
-	j, err := json.Marshal(&blocksOutput)
+	channels, err := stor.ReadChannels(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println(string(j))
 
-	if stor.UpdateAll(channels) {
-		blocksOutput.Message += "done"
-		blocksOutput.Lines = blocks.PrintChannels(channels, false)
-		jd, _ := json.Marshal(&blocksOutput)
-		fmt.Println(string(jd))
-	}
+	// finished channels are sent from update workers, buffer fits all of
+	// them so workers never wait for the main loop
+	updated := make(chan storage.Event, len(channels))
+	stor.Subscribe(func(e storage.Event) {
+		if e.Type != consts.EV_CHANNEL_UPDATED {
+			return
+		}
+		select {
+		case updated <- e:
+		default:
+		}
+	})
 
 	var runMPV bool
 	var plBuffer PlaylistBuffer
+	var videosBuffer models.Playlist
+	var activeData string
+	activeEntry := 1
+	registry := jobs.New()
+	progress := &blocks.Progress{Done: make(map[string]error, len(channels))}
+	// view is used to find out if finished job changed shown data
+	view := viewChannels
+	videosView := viewOther
+
+	// prints frame between input events, active entry stays in place
+	printFrame := func() {
+		blocksOutput.Input = ""
+		blocksOutput.ActEntr = activeEntry
+		j, err := json.Marshal(&blocksOutput)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(j))
+	}
 
-	inDecoder := json.NewDecoder(os.Stdin)
+	// icons of shown videos and playlists are fetched lazily, only for lines
+	// around active entry. Every icon is requested once per session
+	requested := make(map[string]bool, 0)
+	// returns thumbnail of line by its data
+	lineThumbnail := func(data string) (string, map[string]models.Thumbnail, bool) {
+		if p, ok := plBuffer.playlists[data]; ok {
+			return p.ThumbnailPath, p.Thumbnails, true
+		}
+		for _, v := range videosBuffer.Videos {
+			if v.Id == data {
+				return v.ThumbnailPath, v.Thumbnails, true
+			}
+		}
+		return "", nil, false
+	}
+	// starts background download of icons missing around active entry
+	fetchIcons := func() {
+		if appConf.ThumbOff || registry.Running(jobIcons) {
+			return
+		}
+		start, end := activeEntry-screenful, activeEntry+screenful
+		if start < 0 {
+			start = 0
+		}
+		if end > len(blocksOutput.Lines) {
+			end = len(blocksOutput.Lines)
+		}
+		thumbnails := make(map[string]map[string]models.Thumbnail, 0)
+		for i := start; i < end; i++ {
+			line := blocksOutput.Lines[i]
+			path, t, ok := lineThumbnail(line.Data)
+			if !ok || line.Icon == path || requested[path] {
+				continue
+			}
+			requested[path] = true
+			thumbnails[path] = t
+		}
+		if len(thumbnails) == 0 {
+			return
+		}
+		registry.Start(ctx, jobIcons, func(ctx context.Context) error {
+			ytService.FetchThumbnails(ctx, thumbnails)
+			return ctx.Err()
+		})
+	}
+	// replaces placeholders of shown lines with fetched icons, returns
+	// false when nothing changed
+	refreshIcons := func() bool {
+		changed := false
+		for i, line := range blocksOutput.Lines {
+			path, _, ok := lineThumbnail(line.Data)
+			if !ok || line.Icon == path {
+				continue
+			}
+			if icon := blocks.VideoIcon(path); icon != line.Icon {
+				blocksOutput.Lines[i].Icon = icon
+				changed = true
+			}
+		}
+		return changed
+	}
+
+	// re-renders current view after channel cache was updated, returns
+	// false when view doesn't show channel data
+	rerender := func(channelId, message string) bool {
+		switch view {
+		case viewChannel + channelId:
+			blocksOutput.Lines = channelMenu(&stor, channelId)
+			blocksOutput.Message = fmt.Sprintf(
+				"%s %s · %s",
+				message,
+				channels[channelId].Title,
+				ytService.Quota.String(),
+			)
+		case viewUploads + channelId:
+			videos, _, err := stor.CachedUploads(channelId)
+			if err != nil {
+				blocksOutput.Message = message
+				return true
+			}
+			videosBuffer = models.Playlist{
+				Title:  fmt.Sprintf("%s uploads", channels[channelId].Title),
+				Videos: videos,
+			}
+			videosView = view
+			blocksOutput = blocks.PrintVideos(videosBuffer, channelId, readHistory(&stor))
+			blocksOutput.Message += " · " + message
+		case viewPlaylists + channelId:
+			playlists, _, err := stor.CachedPlaylists(channelId)
+			if err != nil {
+				blocksOutput.Message = message
+				return true
+			}
+			plBuffer = newPlBuffer(channels[channelId], playlists)
+			blocksOutput = blocks.PrintPlaylists(playlists, channelId)
+			blocksOutput.Message = fmt.Sprintf(
+				"%s of %s · %s",
+				blocksOutput.Message,
+				plBuffer.channel.Title,
+				message,
+			)
+		default:
+			return false
+		}
+		return true
+	}
+
+	// uploads and playlists are refreshed in background, finished refresh
+	// re-renders shown view. Returns false when refresh is already running
+	refreshVideos := func(channelId string) bool {
+		return registry.Start(ctx, jobVideos+channelId, func(ctx context.Context) error {
+			_, err := stor.ReadUploads(ctx, channelId, true)
+			return err
+		})
+	}
+	refreshPlaylists := func(channelId string) bool {
+		return registry.Start(ctx, jobPlaylists+channelId, func(ctx context.Context) error {
+			_, err := stor.ReadAllPlaylists(ctx, channelId, true)
+			return err
+		})
+	}
+
+	blocksOutput.Lines = blocks.PrintChannels(channels, menuExtras(&stor, dl), progress)
+	blocksOutput.Message = blocks.PrintProgress(progress, len(channels))
+	printFrame()
+
+	all := channels
+	registry.Start(ctx, jobUpdateAll, func(ctx context.Context) error {
+		_, err := stor.UpdateAll(ctx, all, false)
+		return err
+	})
+
+	inputs := make(chan models.BlocksIn)
+	go readInput(inputs)
 	blocksInput := models.BlocksIn{}
 	currentChannel := ""
 
 	for {
-		if err := inDecoder.Decode(&blocksInput); err != nil {
-			log.Fatalf("input decoding error: %s", err.Error())
+		select {
+		case <-ctx.Done():
+			log.Printf("quit: %s\n", ctx.Err().Error())
+			return
+		case in, ok := <-inputs:
+			if !ok {
+				return
+			}
+			blocksInput = in
+		case e := <-updated:
+			if progress == nil {
+				continue
+			}
+			progress.Done[e.ChannelId] = e.Err
+			// titles and icons might change after update
+			if fresh, err := stor.CachedChannels(); err == nil {
+				channels = fresh
+			}
+			message := "updated"
+			if e.Err != nil {
+				message = "update failed: " + service.UserMessage(e.Err)
+			}
+			if view == viewChannels {
+				blocksOutput.Lines = blocks.PrintChannels(channels, menuExtras(&stor, dl), progress)
+				blocksOutput.Message = blocks.PrintProgress(progress, len(all))
+				printFrame()
+			} else if rerender(e.ChannelId, message) {
+				printFrame()
+			}
+			fetchIcons()
+			continue
+		case r := <-registry.Done():
+			switch {
+			case r.Key == jobUpdateAll:
+				for len(updated) > 0 {
+					e := <-updated
+					progress.Done[e.ChannelId] = e.Err
+				}
+				// channels skipped by canceled update
+				for id := range channels {
+					if _, ok := progress.Done[id]; !ok {
+						progress.Done[id] = r.Err
+					}
+				}
+				if view == viewChannels {
+					blocksOutput.Lines = blocks.PrintChannels(channels, menuExtras(&stor, dl), progress)
+					blocksOutput.Message = "updating...done · " + ytService.Quota.String()
+					if r.Err != nil {
+						blocksOutput.Message += " · " + service.UserMessage(r.Err)
+					}
+					printFrame()
+				}
+				progress = nil
+			case strings.HasPrefix(r.Key, jobVideos):
+				message := "updated"
+				if r.Err != nil {
+					message = "error while updating videos: " + service.UserMessage(r.Err)
+				}
+				if rerender(strings.TrimPrefix(r.Key, jobVideos), message) {
+					printFrame()
+				}
+			case strings.HasPrefix(r.Key, jobPlaylists):
+				message := "updated"
+				if r.Err != nil {
+					message = "error while updating playlists: " + service.UserMessage(r.Err)
+				}
+				if rerender(strings.TrimPrefix(r.Key, jobPlaylists), message) {
+					printFrame()
+				}
+			case r.Key == jobIcons:
+				if refreshIcons() {
+					printFrame()
+				}
+			}
+			fetchIcons()
+			continue
 		}
 
 		switch blocksInput.Name {
+		case consts.IN_ACTIVE_ENTRY:
+			activeData = blocksInput.Data
+			if i, err := strconv.Atoi(blocksInput.Value); err == nil {
+				activeEntry = i
+			}
+			fetchIcons()
+			continue
+		case consts.IN_CUSTOM_KEY:
+			data := blocksInput.Data
+			if len(data) == 0 {
+				data = activeData
+			}
+			if len(data) != 11 {
+				continue
+			}
+			v := findVideo(videosBuffer.Videos, data)
+			switch blocksInput.Value {
+			case consts.KEY_ENQUEUE:
+				if n, err := stor.Enqueue(v); err != nil {
+					blocksOutput.Message = "can't enqueue video: " + err.Error()
+				} else {
+					blocksOutput.Message = fmt.Sprintf("queued %s (%d in queue)", v.Title, n)
+				}
+			case consts.KEY_DOWNLOAD:
+				blocksOutput = blocks.PrintDownloadMenu(v, dl.Presets(), currentChannel)
+				view = viewOther
+			default:
+				continue
+			}
 		case consts.IN_SELECT_ENTRY:
-			if len(blocksInput.Data) == 24 {
+			if _, ok := channels[blocksInput.Data]; ok {
 				currentChannel = blocksInput.Data
 			}
-			switch blocksInput.Value {
+			switch blocks.MenuAction(blocksInput.Value) {
 			case "videos":
-				if videos, err := stor.ReadUploads(blocksInput.Data, false); err != nil {
-					blocksOutput.Message = fmt.Sprintf(
-						"videos for %s not ready",
-						channels[blocksInput.Data].Title,
-					)
-					log.Printf(
-						"can't read %s videos due to error: %s",
-						blocksInput.Data,
-						err.Error(),
-					)
+				channelId := blocksInput.Data
+				view = viewUploads + channelId
+				if videos, stale, err := stor.CachedUploads(channelId); err != nil {
+					// nothing is cached yet, videos are shown when fetch is done
+					if !errors.Is(err, os.ErrNotExist) {
+						log.Printf("can't read %s videos due to error: %s", channelId, err.Error())
+					}
+					refreshVideos(channelId)
+					blocksOutput.Lines = channelMenu(&stor, channelId)
+					blocksOutput.Message = "loading videos for " + channels[channelId].Title
 				} else {
-					blocksOutput = blocks.PrintVideos(
-						models.Playlist{
-							Title:  fmt.Sprintf("%s uploads", channels[blocksInput.Data].Title),
-							Videos: videos,
-						},
-						blocksInput.Data,
-					)
+					videosBuffer = models.Playlist{
+						Title:  fmt.Sprintf("%s uploads", channels[channelId].Title),
+						Videos: videos,
+					}
+					blocksOutput = blocks.PrintVideos(videosBuffer, channelId, readHistory(&stor))
+					videosView = view
+					if stale && refreshVideos(channelId) {
+						blocksOutput.Message += " · updating"
+					}
 				}
 			case "playlists":
-				if playlists, err := stor.ReadAllPlaylists(blocksInput.Data, false); err != nil {
-					blocksOutput.Message = fmt.Sprintf("playlists %s not ready", blocksInput.Data)
-					log.Printf("can't read playlists for %s due to error: %s",
-						channels[blocksInput.Data].Title,
-						err.Error(),
-					)
+				channelId := blocksInput.Data
+				view = viewPlaylists + channelId
+				if playlists, stale, err := stor.CachedPlaylists(channelId); err != nil {
+					// nothing is cached yet, playlists are shown when fetch is done
+					if !errors.Is(err, os.ErrNotExist) {
+						log.Printf("can't read playlists for %s due to error: %s", channelId, err.Error())
+					}
+					refreshPlaylists(channelId)
+					blocksOutput.Lines = channelMenu(&stor, channelId)
+					blocksOutput.Message = "loading playlists for " + channels[channelId].Title
 				} else {
-					blocksOutput = blocks.PrintPlaylists(playlists, blocksInput.Data)
-					plBuffer = newPlBuffer(channels[blocksInput.Data], playlists)
+					blocksOutput = blocks.PrintPlaylists(playlists, channelId)
+					plBuffer = newPlBuffer(channels[channelId], playlists)
 					blocksOutput.Message = fmt.Sprintf(
 						"%s of %s",
 						blocksOutput.Message,
 						plBuffer.channel.Title,
 					)
+					if stale && refreshPlaylists(channelId) {
+						blocksOutput.Message += " · updating"
+					}
 				}
 			case "update playlists":
-				blocksOutput.Message = "updating playlists for " + channels[blocksInput.Data].Title
-				j, _ := json.Marshal(&blocksOutput)
-				fmt.Println(string(j))
-
-				if _, err := stor.ReadAllPlaylists(blocksInput.Data, true); err != nil {
-					blocksOutput.Message = "error while updating playlists..."
+				channelId := blocksInput.Data
+				if refreshPlaylists(channelId) {
+					blocksOutput.Message = "updating playlists for " + channels[channelId].Title
 				} else {
-					blocksOutput.Message = "done..."
+					blocksOutput.Message = "playlists update is already running for " + channels[channelId].Title
 				}
-
-				blocksOutput.Lines = blocks.PrintChannelMenu(blocksInput.Data)
-				blocksOutput.Message += fmt.Sprintf(" %s", channels[blocksInput.Data].Title)
+				blocksOutput.Lines = channelMenu(&stor, channelId)
+				view = viewChannel + channelId
 			case "update videos":
-				blocksOutput.Message = "updating videos for " + channels[blocksInput.Data].Title
-				j, _ := json.Marshal(&blocksOutput)
-				fmt.Println(string(j))
-
-				if _, err := stor.ReadUploads(blocksInput.Data, true); err != nil {
-					blocksOutput.Message = "error while updating videos..."
+				channelId := blocksInput.Data
+				if refreshVideos(channelId) {
+					blocksOutput.Message = "updating videos for " + channels[channelId].Title
+				} else {
+					blocksOutput.Message = "videos update is already running for " + channels[channelId].Title
+				}
+				blocksOutput.Lines = channelMenu(&stor, channelId)
+				view = viewChannel + channelId
+			case "play all":
+				// private videos are hidden in menu and can't be played
+				videos := player.Playable(videosBuffer.Videos)
+				if err := mpv.Play(videos...); err != nil {
+					blocksOutput.Message = "play all error: " + err.Error()
+				} else {
+					blocksOutput.Message = fmt.Sprintf("playing %d videos", len(videos))
+					runMPV = true
+				}
+			case "play queue":
+				queue, err := stor.ReadQueue()
+				if err == nil {
+					err = mpv.Play(queue...)
+				}
+				if err != nil {
+					blocksOutput.Message = "play queue error: " + err.Error()
 				} else {
-					blocksOutput.Message = "done..."
+					blocksOutput.Message = fmt.Sprintf("playing %d videos", len(queue))
+					runMPV = true
+					if err := stor.ClearQueue(); err != nil {
+						log.Printf("clear queue error: %s", err.Error())
+					}
+					blocksOutput.Lines = blocks.PrintChannels(channels, menuExtras(&stor, dl), progress)
+					view = viewChannels
+				}
+			case "clear queue":
+				if err := stor.ClearQueue(); err != nil {
+					blocksOutput.Message = "clear queue error: " + err.Error()
+				} else {
+					blocksOutput.Message = "queue cleared"
+					blocksOutput.Lines = blocks.PrintChannels(channels, menuExtras(&stor, dl), progress)
+					view = viewChannels
 				}
-				blocksOutput.Lines = blocks.PrintChannelMenu(blocksInput.Data)
-				blocksOutput.Message += fmt.Sprintf(" %s", channels[blocksInput.Data].Title)
 			case "back":
 				if v := strings.Split(blocksInput.Data, ":"); v != nil && len(v) == 2 {
 					switch v[0] {
 					case "channel":
-						blocksOutput.Lines = blocks.PrintChannelMenu(v[1])
+						blocksOutput.Lines = channelMenu(&stor, v[1])
 						blocksOutput.Message = channels[v[1]].Title
+						view = viewChannel + v[1]
+					case "videos":
+						blocksOutput = blocks.PrintVideos(videosBuffer, v[1], readHistory(&stor))
+						view = videosView
 					}
 				} else {
-					blocksOutput.Message = "channels list"
-					blocksOutput.Lines = blocks.PrintChannels(channels, false)
+					blocksOutput.Message = "channels list · " + ytService.Quota.String()
+					if progress != nil {
+						blocksOutput.Message = blocks.PrintProgress(progress, len(all))
+					}
+					blocksOutput.Lines = blocks.PrintChannels(channels, menuExtras(&stor, dl), progress)
+					view = viewChannels
 				}
 			default:
-				switch len(blocksInput.Data) {
-				case 34: // playlist
+				switch {
+				case blocksInput.Data == consts.D_QUEUE:
+					if queue, err := stor.ReadQueue(); err != nil {
+						blocksOutput.Message = "read queue error"
+					} else {
+						blocksOutput = blocks.PrintQueue(queue)
+						videosBuffer = models.Playlist{Title: "queue", Videos: queue}
+						view, videosView = viewOther, viewOther
+					}
+				case len(blocksInput.Data) == 34: // playlist
 					if plBuffer.channel.Id == currentChannel {
 						log.Println("read playlists from buffer")
+						videosBuffer = plBuffer.playlists[blocksInput.Data]
 						blocksOutput = blocks.PrintVideos(
-							plBuffer.playlists[blocksInput.Data],
+							videosBuffer,
 							currentChannel,
+							readHistory(&stor),
 						)
-					} else if playlists, err := stor.ReadAllPlaylists(currentChannel, false); err != nil {
+						view, videosView = viewOther, viewOther
+					} else if playlists, _, err := stor.CachedPlaylists(currentChannel); err != nil {
 						blocksOutput.Message = "get playlist videos error"
 					} else {
-						blocksOutput = blocks.PrintVideos(playlists[blocksInput.Data], currentChannel)
+						videosBuffer = playlists[blocksInput.Data]
+						blocksOutput = blocks.PrintVideos(videosBuffer, currentChannel, readHistory(&stor))
+						view, videosView = viewOther, viewOther
+					}
+				case blocksInput.Data == consts.D_DOWNLOADS:
+					finished, err := stor.ReadDownloads()
+					if err != nil {
+						log.Printf("read downloads error: %s", err.Error())
+					}
+					blocksOutput = blocks.PrintDownloads(dl.Active(), finished)
+					view = viewOther
+				case strings.HasPrefix(blocksInput.Data, consts.D_DOWNLOAD):
+					v := strings.TrimPrefix(blocksInput.Data, consts.D_DOWNLOAD)
+					i := strings.LastIndex(v, ":")
+					if i < 0 {
+						break
+					}
+					video := findVideo(videosBuffer.Videos, v[i+1:])
+					blocksOutput = blocks.PrintVideos(videosBuffer, currentChannel, readHistory(&stor))
+					view = videosView
+					if err := dl.Enqueue(video, currentChannel, v[:i]); err != nil {
+						blocksOutput.Message = "download error: " + err.Error()
+					} else {
+						blocksOutput.Message = fmt.Sprintf("downloading %s (%s)", video.Title, v[:i])
 					}
-				case 11:
-					if runMPV = openInMPV(blocksInput.Data); !runMPV {
+				case strings.HasPrefix(blocksInput.Data, consts.D_FILE):
+					if err := mpv.PlayFile(strings.TrimPrefix(blocksInput.Data, consts.D_FILE)); err != nil {
 						blocksOutput.Message += " : error"
+					} else {
+						runMPV = true
+					}
+				case strings.HasPrefix(blocksInput.Data, consts.D_RESUME):
+					id := strings.TrimPrefix(blocksInput.Data, consts.D_RESUME)
+					entry := readHistory(&stor)[id]
+					if err := mpv.PlayFrom(entry.Position, findVideo(videosBuffer.Videos, id)); err != nil {
+						blocksOutput.Message += " : error"
+					} else {
+						runMPV = true
+					}
+				case strings.HasPrefix(blocksInput.Data, consts.D_START):
+					id := strings.TrimPrefix(blocksInput.Data, consts.D_START)
+					if err := mpv.Play(findVideo(videosBuffer.Videos, id)); err != nil {
+						blocksOutput.Message += " : error"
+					} else {
+						runMPV = true
+					}
+				case len(blocksInput.Data) == 11:
+					video := findVideo(videosBuffer.Videos, blocksInput.Data)
+					if entry := readHistory(&stor)[video.Id]; entry.Resumable() {
+						blocksOutput = blocks.PrintVideoMenu(video, entry, currentChannel)
+						view = viewOther
+					} else if err := mpv.Play(video); err != nil {
+						blocksOutput.Message += " : error"
+					} else {
+						runMPV = true
 					}
 				default:
 					blocksOutput.Message = channels[blocksInput.Data].Title
-					blocksOutput.Lines = blocks.PrintChannelMenu(blocksInput.Data)
+					blocksOutput.Lines = channelMenu(&stor, blocksInput.Data)
+					view = viewChannel + blocksInput.Data
 				}
 			}
 		}
 
+		exit := runMPV && !appConf.KeepOpen
+		if n := len(dl.Active()); exit && n > 0 {
+			// closing menu would kill running downloads
+			exit = false
+			blocksOutput.Message += fmt.Sprintf(" (%d downloads in progress)", n)
+		}
+
 		blocksOutput.Input = ""
 		blocksOutput.ActEntr = 1
+		if blocksInput.Name == consts.IN_CUSTOM_KEY && blocksInput.Value == consts.KEY_ENQUEUE {
+			// stay on the same line after enqueue
+			blocksOutput.ActEntr = activeEntry
+		}
 		j, err := json.Marshal(&blocksOutput)
 		if err != nil {
 			log.Fatalf("input encoding error: %s", err.Error())
 		}
 		fmt.Println(string(j))
+		activeEntry = blocksOutput.ActEntr
+		fetchIcons()
 
-		if runMPV {
+		if exit {
 			time.Sleep(2 * time.Second)
+			ytService.Quota.Flush()
 			os.Exit(0)
 		}
+		runMPV = false
 	}
 }
@@ -1,50 +1,111 @@
 package downloader
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/su55y/yt_feed/pkg/fsutil"
 )
 
+// max simultaneous downloads across all DownloadAll calls
+const defaultConcurrency = 8
+
+var slots = make(chan struct{}, defaultConcurrency)
+
+// shared between downloads, so connections to thumbnails host are reused
+var client = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   defaultConcurrency,
+	},
+}
+
+// SetConcurrency limits simultaneous downloads, should be called before
+// any download is started
+func SetConcurrency(n int) {
+	if n > 0 {
+		slots = make(chan struct{}, n)
+	}
+}
+
 func exists(path string) bool {
 	_, err := os.Stat(path)
 	return !errors.Is(err, os.ErrNotExist) && err == nil
 }
 
-func download_file(url, path string, wg *sync.WaitGroup) {
-	defer wg.Done()
-
+// downloads image to temp file and renames it to path, so failed download
+// never leaves partial file
+func download_file(ctx context.Context, url, path string) error {
 	if exists(path) {
-		return
+		return nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		log.Printf("download from '%s' error: %s\n", url, err)
-		return
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
 
-	out, err := os.Create(path)
-	if err != nil {
-		log.Printf("create '%s' file error: %s\n", path, err.Error())
-		return
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status '%s'", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "image/") {
+		return fmt.Errorf("unexpected content type '%s'", ct)
 	}
-	defer out.Close()
 
-	io.Copy(out, resp.Body)
+	return fsutil.WriteFileAtomic(path, func(w io.Writer) error {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	})
 }
 
-func DownloadAll(urls map[string]string) {
+// DownloadAll downloads urls to their paths, returns errors of failed
+// downloads by path
+func DownloadAll(ctx context.Context, urls map[string]string) map[string]error {
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	errs := make(map[string]error, 0)
 	for k, u := range urls {
-		if len(u) > 0 {
-			wg.Add(1)
-			go download_file(u, k, &wg)
+		if len(u) == 0 {
+			continue
 		}
+		wg.Add(1)
+		go func(url, path string) {
+			defer wg.Done()
+			if err := download_file(ctx, url, path); err != nil {
+				mu.Lock()
+				errs[path] = fmt.Errorf("download from '%s': %w", url, err)
+				mu.Unlock()
+			}
+		}(u, k)
 	}
 	wg.Wait()
+	return errs
 }
@@ -0,0 +1,243 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/su55y/yt_feed/pkg/fsutil"
+)
+
+// pixels darker than this are treated as black bars
+const blackThreshold = 24
+
+// ImageOptions of thumbnail post-processing, zero value keeps image as is
+// but still converts it to PNG
+type ImageOptions struct {
+	// max width and height, 0 keeps original size
+	Size int
+	// crop black bars of letterboxed thumbnails
+	Crop bool
+	// crop center square and make it circular, for channel avatars
+	Circle bool
+}
+
+// VariantPath returns path of processed image, variants of different
+// options are cached side by side, e.g. 'hq_id_64c.png'
+func VariantPath(path string, opts ImageOptions) string {
+	suffix := fmt.Sprintf("_%d", opts.Size)
+	if opts.Crop {
+		suffix += "c"
+	}
+	if opts.Circle {
+		suffix += "o"
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + suffix + ".png"
+}
+
+// Process decodes src image, applies options and writes PNG to dst
+func Process(src, dst string, opts ImageOptions) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if opts.Crop {
+		img = cropBars(img)
+	}
+	if opts.Circle {
+		img = centerSquare(img)
+	}
+	if opts.Size > 0 {
+		img = fit(img, opts.Size)
+	}
+	if opts.Circle {
+		img = circle(img)
+	}
+
+	return fsutil.WriteFileAtomic(dst, func(w io.Writer) error {
+		return png.Encode(w, img)
+	})
+}
+
+// ProcessAll makes variants of existing images, returns errors by path
+func ProcessAll(paths []string, opts ImageOptions) map[string]error {
+	errs := make(map[string]error, 0)
+	for _, p := range paths {
+		dst := VariantPath(p, opts)
+		if exists(dst) {
+			continue
+		}
+		if !exists(p) {
+			errs[p] = errors.New("source image is missing")
+			continue
+		}
+		if err := Process(p, dst, opts); err != nil {
+			errs[p] = err
+		}
+	}
+	return errs
+}
+
+// removes dark rows and columns around image, at least half of image is kept
+func cropBars(img image.Image) image.Image {
+	b := img.Bounds()
+	dark := func(x, y int) bool {
+		r, g, bl, _ := img.At(x, y).RGBA()
+		// luma of 16-bit channels scaled to 8 bits
+		return (299*r+587*g+114*bl)/1000>>8 < blackThreshold
+	}
+	darkRow := func(y int) bool {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !dark(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+	darkCol := func(x, minY, maxY int) bool {
+		for y := minY; y < maxY; y++ {
+			if !dark(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+
+	top, bottom := b.Min.Y, b.Max.Y
+	for top < bottom && darkRow(top) {
+		top++
+	}
+	for bottom > top && darkRow(bottom-1) {
+		bottom--
+	}
+	left, right := b.Min.X, b.Max.X
+	for left < right && darkCol(left, top, bottom) {
+		left++
+	}
+	for right > left && darkCol(right-1, top, bottom) {
+		right--
+	}
+
+	// whole image is dark or bars are suspiciously big
+	if (bottom-top)*2 < b.Dy() || (right-left)*2 < b.Dx() {
+		return img
+	}
+	return subImage(img, image.Rect(left, top, right, bottom))
+}
+
+func centerSquare(img image.Image) image.Image {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return subImage(img, image.Rect(x, y, x+side, y+side))
+}
+
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
+
+// scales image to fit into size x size keeping aspect ratio
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := size, size
+	if b.Dx() > b.Dy() {
+		h = int(math.Round(float64(size) * float64(b.Dy()) / float64(b.Dx())))
+	} else {
+		w = int(math.Round(float64(size) * float64(b.Dx()) / float64(b.Dy())))
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return resize(img, w, h)
+}
+
+// area averaging resize, every destination pixel is weighted mean of
+// source pixels it covers
+func resize(img image.Image, w, h int) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	sx := float64(b.Dx()) / float64(w)
+	sy := float64(b.Dy()) / float64(h)
+
+	for y := 0; y < h; y++ {
+		y0, y1 := float64(y)*sy, float64(y+1)*sy
+		for x := 0; x < w; x++ {
+			x0, x1 := float64(x)*sx, float64(x+1)*sx
+			var r, g, bl, a, total float64
+			for py := int(y0); float64(py) < y1 && py < b.Dy(); py++ {
+				wy := math.Min(y1, float64(py+1)) - math.Max(y0, float64(py))
+				for px := int(x0); float64(px) < x1 && px < b.Dx(); px++ {
+					wx := math.Min(x1, float64(px+1)) - math.Max(x0, float64(px))
+					weight := wx * wy
+					cr, cg, cb, ca := img.At(b.Min.X+px, b.Min.Y+py).RGBA()
+					r += float64(cr) * weight
+					g += float64(cg) * weight
+					bl += float64(cb) * weight
+					a += float64(ca) * weight
+					total += weight
+				}
+			}
+			if total == 0 || a == 0 {
+				continue
+			}
+			// colors are premultiplied, divide them by alpha for NRGBA
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(math.Round(r / a * 0xff)),
+				G: uint8(math.Round(g / a * 0xff)),
+				B: uint8(math.Round(bl / a * 0xff)),
+				A: uint8(math.Round(a / total / 0x101)),
+			})
+		}
+	}
+	return dst
+}
+
+// applies circular alpha mask with antialiased edge
+func circle(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	cx, cy := float64(b.Dx())/2, float64(b.Dy())/2
+	radius := math.Min(cx, cy)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			coverage := math.Max(0, math.Min(1, radius-d+0.5))
+			c := dst.NRGBAAt(x, y)
+			c.A = uint8(math.Round(float64(c.A) * coverage))
+			dst.SetNRGBA(x, y, c)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package downloader
+
+import "os"
+
+// hardlinks count is unknown here, so stored objects are never pruned
+func links(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package downloader
+
+import (
+	"os"
+	"syscall"
+)
+
+// returns hardlinks count of file, 0 when it's unknown
+func links(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 0
+}
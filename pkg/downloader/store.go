@@ -0,0 +1,95 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Dedup moves content of file at path into content-addressed store in dir
+// and replaces file with hardlink to it, so identical files share single
+// copy on disk. Files are never modified in place, replaced files only
+// drop their link to stored object
+func Dedup(dir, path string) error {
+	sum, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	object := filepath.Join(dir, sum[:2], sum)
+	if err := os.MkdirAll(filepath.Dir(object), os.ModePerm); err != nil {
+		return err
+	}
+
+	err = os.Link(path, object)
+	if err == nil || !errors.Is(err, os.ErrExist) {
+		return err
+	}
+
+	// identical file is already stored, path is replaced with link to it
+	stored, err := os.Stat(object)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(stored, info) {
+		return nil
+	}
+	tmp := path + ".tmp.link"
+	os.Remove(tmp)
+	if err := os.Link(object, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// PruneStore removes stored objects which are not linked from anywhere
+// else, returns count and size of removed objects
+func PruneStore(dir string) (int, int64, error) {
+	removed, freed := 0, int64(0)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || links(info) != 1 {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	return removed, freed, err
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}